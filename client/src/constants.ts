@@ -8,6 +8,16 @@ export const EVENT_STDERR = "stderr";
 export const EVENT_ERROR = "error";
 export const EVENT_CLEAR = "clear";
 export const EVENT_DONE = "done";
+export const EVENT_SANDBOX_VIOLATION = "sandbox-violation";
+export const EVENT_RESULT = "result";
+export const EVENT_TRUNCATED = "truncated";
+export const EVENT_IMAGE = "image";
+export const EVENT_HTML = "html";
+export const EVENT_TABLE = "table";
+export const EVENT_EXECUTION = "execution";
+export const EVENT_MISSED = "missed";
+export const SSE_CLOSED = 2; // readyState of a closed SSE
+export const RECONNECT_DELAY = 1000; // ms, before following a run again when the connection drops
 
 export const VIM = "vim"
 export const EMACS = "emacs"
@@ -25,6 +35,7 @@ export const KEY_BINDINGS_KEY = "keyBindings";
 export const LANGUAGE_KEY = "languageKey";
 export const IS_LINT_ON_KEY = "isLintOn";
 export const IS_AUTOCOMPLETION_ON_KEY = "isAutoCompletionOn";
+export const IS_NETWORK_ON_KEY = "isNetworkOn";
 export const EDITOR_SIZE_KEY = "editorSize";
 export const DRAWER_SIZE_KEY = "drawerSize";
 export const OPENED_DRAWER_KEY = "openedDrawer";
@@ -41,6 +52,7 @@ export const DEFAULT_GO_VERSION = "1";
 export const DEFAULT_KEY_BINDINGS: KeyBindingsType = "";
 export const DEFAULT_LINT_ON = "true";
 export const DEFAULT_AUTOCOMPLETION_ON = "true";
+export const DEFAULT_NETWORK_ON = "false";
 export const DEFAULT_SHOW_TERMINAL = "true";
 
 export const RESIZABLE_HANDLER_WIDTH = 5;
@@ -73,7 +85,6 @@ export const INACTIVE_TEXT_CLASS = "text-gray-400 dark:text-gray-600"
 
 export const SNIPPET_REGEX = /\/snippets\/([a-zA-Z0-9-_]+)/g; // url base64 encoded
 export const SOURCE_REGEX = /\/sources\/([a-zA-Z0-9-_]+)/g; // url base64 encoded
-export const STATS_INFO_PREFIX = "STATS_INFO:"
 
 export const HTTP_INTERNAL_ERROR = 500
 export const HTTP_NOT_FOUND = 404
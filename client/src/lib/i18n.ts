@@ -1217,6 +1217,38 @@ export const TRANSLATE: Record<string, Record<languages, string>> = {
         cs: "Automatické dokončování",
         sk: "Automatické dokončovanie",
     },
+    network: {
+        en: "Loopback Network",
+        zh_CN: "本地回环网络",
+        zh_TW: "本地迴環網路",
+        ja: "ループバックネットワーク",
+        ko: "루프백 네트워크",
+        fr: "Réseau de bouclage",
+        de: "Loopback-Netzwerk",
+        es: "Red de bucle local",
+        it: "Rete di loopback",
+        ru: "Локальная петлевая сеть",
+        hi: "लूपबैक नेटवर्क",
+        pt_BR: "Rede de loopback",
+        pt_PT: "Rede de loopback",
+        vi: "Mạng loopback",
+        th: "เครือข่ายลูปแบ็ก",
+        tr: "Geri döngü ağı",
+        id: "Jaringan loopback",
+        uk: "Локальна петлева мережа",
+        pl: "Sieć pętli zwrotnej",
+        nl: "Loopback-netwerk",
+        he: "רשת לולאה חוזרת",
+        ar: "شبكة الاسترجاع",
+        ro: "Rețea loopback",
+        hu: "Visszacsatolási hálózat",
+        da: "Loopback-netværk",
+        fi: "Loopback-verkko",
+        sv: "Loopback-nätverk",
+        no: "Loopback-nettverk",
+        cs: "Loopback síť",
+        sk: "Loopback sieť",
+    },
     rename: {
         en: "Rename",
         zh_CN: "重命名",
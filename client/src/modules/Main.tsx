@@ -7,17 +7,28 @@ import {
     IS_LINT_ON_KEY,
     DEBOUNCE_TIME,
     KEY_BINDINGS_KEY,
-    STATS_INFO_PREFIX,
     EVENT_STDOUT,
     EVENT_ERROR,
     EVENT_STDERR,
     EVENT_CLEAR,
     EVENT_DONE,
+    EVENT_SANDBOX_VIOLATION,
+    EVENT_RESULT,
+    EVENT_TRUNCATED,
+    EVENT_IMAGE,
+    EVENT_HTML,
+    EVENT_TABLE,
+    EVENT_EXECUTION,
+    EVENT_MISSED,
+    HTTP_NOT_FOUND,
+    SSE_CLOSED,
+    RECONNECT_DELAY,
     IS_VERTICAL_LAYOUT_KEY,
     EDITOR_SIZE_MIN,
     EDITOR_SIZE_MAX,
     TITLE,
     IS_AUTOCOMPLETION_ON_KEY,
+    IS_NETWORK_ON_KEY,
     DRAWER_SIZE_KEY,
     RESIZABLE_HANDLER_WIDTH,
     DRAWER_SIZE_MIN, DRAWER_SIZE_MAX, NO_OPENED_DRAWER, DEBOUNCE_TIME_LONG,
@@ -40,10 +51,11 @@ import {
     getLintOn,
     getUrl,
     getIsVerticalLayout,
-    getAutoCompletionOn, getDrawerSize, AppCtx, isUserCode
+    getAutoCompletionOn, getNetworkOn, getDrawerSize, AppCtx, isUserCode, formatMicroseconds
 } from "../utils.ts";
 import Settings from "./Settings.tsx";
 import {
+    ExecutionResultI,
     KeyBindingsType,
     LSPDocumentSymbol,
     patchI,
@@ -72,6 +84,7 @@ const resizeHandlerHoverClasses = "w-1 z-10 hover:bg-cyan-500 transition-colors"
 // default values
 const initialIsLintOn = getLintOn()
 const initialIsAutoCompletionOn = getAutoCompletionOn()
+const initialIsNetworkOn = getNetworkOn()
 const initialIsVerticalLayout = getIsVerticalLayout();
 const initialEditorSize = getEditorSize()
 const initialDrawerSize = getDrawerSize()
@@ -113,11 +126,13 @@ export default function Component() {
     const valueRef = useRef(value);
     const fileRef = useRef(file);
     const isRunningRef = useRef(isRunning);
+    const isNetworkOnRef = useRef(initialIsNetworkOn);
 
     // mode status
     const [keyBindings, setKeyBindings] = useState<KeyBindingsType>(initialKeyBindings);
     const [isLintOn, setIsLintOn] = useState<boolean>(initialIsLintOn)
     const [isAutoCompletionOn, setIsAutoCompletionOn] = useState<boolean>(initialIsAutoCompletionOn)
+    const [isNetworkOn, setIsNetworkOn] = useState<boolean>(initialIsNetworkOn)
 
     // IMPORTANT: update the ref when the state changes
     useEffect(() => {
@@ -220,42 +235,119 @@ export default function Component() {
             setPatch({value: formatted, keepCursor: true})
             valueRef.current = formatted // important: update immediately
 
-            const source = new SSE(getUrl("/execute"), {
-                headers: {'Content-Type': 'application/json'},
-                payload: JSON.stringify({code: valueRef.current, version: goVersion})
-            });
-
-            source.addEventListener(EVENT_STDOUT, ({data}: MessageEvent) => {
-                setResult(prev => prev.concat({type: EVENT_STDOUT, content: data}))
-            });
-
-            source.addEventListener(EVENT_ERROR, ({data}: MessageEvent) => {
-                setError(data)
-                setIsRunning(false)
-            });
-
-            source.addEventListener(EVENT_STDERR, ({data}: MessageEvent) => {
-                // special case -- stat info wrapped in the stderr
-                if (data.startsWith(STATS_INFO_PREFIX)) {
-                    const [time, mem] = data.replace(STATS_INFO_PREFIX, "").split(";")
-                    setInfo(`Time: ${time} | Memory: ${mem}kb`)
-                    return
+            // the run goes on when the connection drops, it is followed again from the last event received
+            let executionId = "", lastEventId = "", ended = false
+
+            const follow = (source: SSE) => {
+                // the events of the run have ids, the position to follow from
+                const on = (event: string, handler: (data: string) => void) => {
+                    source.addEventListener(event, (e: MessageEvent & { id?: string }) => {
+                        if (e.id) {
+                            lastEventId = e.id
+                        }
+                        handler(e.data)
+                    });
                 }
 
-                // TODO: generate annotation or marker
-                // TODO: annotation or marker
+                on(EVENT_EXECUTION, data => {
+                    executionId = data
+                });
 
-                setResult(prev => prev.concat({type: EVENT_STDERR, content: data}))
-            });
+                // the lines of output come in batches
+                on(EVENT_STDOUT, data => {
+                    setResult(prev => prev.concat(data.split("\n").map((content: string) => ({type: EVENT_STDOUT, content}))))
+                });
 
-            // clear the screen
-            source.addEventListener(EVENT_CLEAR, () => {
-                setResult([])
-            });
+                // an error without an id is one of the connection, which is followed again once it is closed
+                source.addEventListener(EVENT_ERROR, (e: MessageEvent & { id?: string, responseCode?: number }) => {
+                    if (!e.id && executionId && e.responseCode !== HTTP_NOT_FOUND) {
+                        return
+                    }
+                    ended = true
+                    setError(e.data)
+                    setIsRunning(false)
+                });
+
+                on(EVENT_STDERR, data => {
+                    // TODO: generate annotation or marker
+                    // TODO: annotation or marker
+
+                    setResult(prev => prev.concat(data.split("\n").map((content: string) => ({type: EVENT_STDERR, content}))))
+                });
+
+                // rich output of the display package, rendered by the terminal
+                for (const event of [EVENT_IMAGE, EVENT_HTML, EVENT_TABLE] as const) {
+                    on(event, data => {
+                        setResult(prev => prev.concat({type: event, content: data}))
+                    });
+                }
 
-            source.addEventListener(EVENT_DONE, () => {
-                setIsRunning(false)
-            });
+                // a syscall the sandbox does not allow, the program gets "operation not permitted"
+                on(EVENT_SANDBOX_VIOLATION, data => {
+                    setResult(prev => prev.concat({
+                        type: EVENT_STDERR,
+                        content: `[sandbox] the system call "${data}" is not allowed`,
+                    }))
+                });
+
+                // the output is over the limits of the server, the program has been stopped
+                on(EVENT_TRUNCATED, data => {
+                    const {bytes, lines, max_bytes, max_lines} = JSON.parse(data)
+                    setResult(prev => prev.concat({
+                        type: EVENT_STDERR,
+                        content: `[sandbox] output truncated: ${lines} lines, ${bytes} bytes written, the limits are ${max_lines} lines, ${max_bytes} bytes`,
+                    }))
+                });
+
+                // the server no longer has some of the events since the connection dropped
+                on(EVENT_MISSED, data => {
+                    setResult(prev => prev.concat({
+                        type: EVENT_STDERR,
+                        content: `[sandbox] ${data} events of output were missed while reconnecting`,
+                    }))
+                });
+
+                // how the run ended, the error or done event follows
+                on(EVENT_RESULT, data => {
+                    const result: ExecutionResultI = JSON.parse(data)
+                    if (result.stage !== "run") {
+                        return
+                    }
+                    const time = formatMicroseconds(result.wall_time_us)
+                    const cpu = formatMicroseconds(result.cpu_user_us + result.cpu_system_us)
+                    const memory = Math.round(result.peak_memory_bytes / 1024)
+                    setInfo(`Time: ${time} | CPU: ${cpu} | Memory: ${memory}kb${result.truncated ? " | Output truncated" : ""}`)
+                });
+
+                // clear the screen
+                on(EVENT_CLEAR, () => {
+                    setResult([])
+                });
+
+                on(EVENT_DONE, () => {
+                    ended = true
+                    setIsRunning(false)
+                });
+
+                source.addEventListener("readystatechange", (e: { readyState: number }) => {
+                    if (e.readyState !== SSE_CLOSED || ended) {
+                        return
+                    }
+                    if (!executionId) {
+                        setIsRunning(false)
+                        return
+                    }
+                    setTimeout(() => follow(new SSE(getUrl(`/executions/${executionId}/events`), {
+                        method: "GET",
+                        headers: {"Last-Event-ID": lastEventId},
+                    })), RECONNECT_DELAY)
+                });
+            }
+
+            follow(new SSE(getUrl("/execute"), {
+                headers: {'Content-Type': 'application/json'},
+                payload: JSON.stringify({code: valueRef.current, version: goVersion, network: isNetworkOnRef.current})
+            }));
         } catch (e) {
             const err = e as Error
             // TODO: annotation or marker
@@ -318,6 +410,12 @@ export default function Component() {
         setIsAutoCompletionOn(!isAutoCompletionOn);
     }
 
+    function onNetwork() {
+        localStorage.setItem(IS_NETWORK_ON_KEY, JSON.stringify(!isNetworkOn));
+        isNetworkOnRef.current = !isNetworkOn
+        setIsNetworkOn(!isNetworkOn);
+    }
+
     function onKeyBindingsChange(value: KeyBindingsType) {
         localStorage.setItem(KEY_BINDINGS_KEY, value);
         setKeyBindings(value)
@@ -370,6 +468,8 @@ export default function Component() {
                 onLint={onLint}
                 isAutoCompletionOn={isAutoCompletionOn}
                 onAutoCompletion={onAutoCompletion}
+                isNetworkOn={isNetworkOn}
+                onNetwork={onNetwork}
             />
 
             <div
@@ -29,6 +29,9 @@ export default function Component(props: {
     // for auto completion
     isAutoCompletionOn: boolean;
     onAutoCompletion: () => void;
+    // for loopback network in the sandbox
+    isNetworkOn: boolean;
+    onNetwork: () => void;
 }) {
     const {
         lan, updateLan,
@@ -48,6 +51,9 @@ export default function Component(props: {
         // for auto completion
         isAutoCompletionOn, onAutoCompletion,
 
+        // for network
+        isNetworkOn, onNetwork,
+
         // for modal
         show, setShow,
     } = props;
@@ -94,6 +100,11 @@ export default function Component(props: {
                             <Label value={TRANSLATE.autoCompletion[lan]}/>
                             <ToggleSwitch checked={isAutoCompletionOn} onChange={onAutoCompletion}/>
                         </Row>
+
+                        <Row>
+                            <Label value={TRANSLATE.network[lan]}/>
+                            <ToggleSwitch checked={isNetworkOn} onChange={onNetwork}/>
+                        </Row>
                     </Grid>
 
                     <Divider horizontal={true} className={"my-4"}/>
@@ -1,13 +1,48 @@
 import {ClickBoard, Wrapper} from "./Common.tsx";
 import {AppCtx} from "../utils.ts";
-import {resultI} from "../types";
-import {EVENT_STDERR} from "../constants.ts";
+import {imageI, resultI, tableI} from "../types";
+import {EVENT_HTML, EVENT_IMAGE, EVENT_STDERR, EVENT_STDOUT, EVENT_TABLE} from "../constants.ts";
 import {TRANSLATE} from "../lib/i18n.ts";
 import {useContext} from "react";
 
 const errorColor = "text-red-700 dark:text-red-500"
 const infoColor = "text-green-600 dark:text-green-300"
 
+// rich output of the display package, HTML is sandboxed so that scripts in it do not run
+function Rich(props: { item: resultI }) {
+    const {type, content} = props.item
+
+    switch (type) {
+        case EVENT_IMAGE: {
+            const {mime, data}: imageI = JSON.parse(content)
+            return <img className={"my-1 max-w-full self-start"} alt={""} src={`data:${mime};base64,${data}`}/>
+        }
+        case EVENT_HTML:
+            return <iframe className={"my-1 w-full border-0 bg-white"} sandbox={""} srcDoc={content}/>
+        case EVENT_TABLE: {
+            const {columns, rows}: tableI = JSON.parse(content)
+            return (
+                <table className={"my-1 self-start border-collapse"}>
+                    <thead>
+                    <tr>
+                        {columns.map((column, i) => <th key={i} className={"border border-neutral-400 px-2"}>{column}</th>)}
+                    </tr>
+                    </thead>
+                    <tbody>
+                    {rows.map((row, i) => (
+                        <tr key={i}>
+                            {row.map((cell, j) => <td key={j} className={"border border-neutral-400 px-2"}>{String(cell)}</td>)}
+                        </tr>
+                    ))}
+                    </tbody>
+                </table>
+            )
+        }
+        default:
+            return null
+    }
+}
+
 export default function Component(props: {
     result: resultI[],
     info: string,
@@ -21,7 +56,7 @@ export default function Component(props: {
         <Wrapper
             className={`flex flex-col overflow-hidden bg-neutral-200 pb-5 dark:bg-neutral-800 ${textSize}`}>
             <ClickBoard content={
-                result.map(item => item.content).join("\n")
+                result.filter(item => item.type === EVENT_STDOUT || item.type === EVENT_STDERR).map(item => item.content).join("\n")
             }/>
 
             <div className={"terminal-info hide-scrollbar flex flex-col justify-center overflow-x-auto overflow-y-hidden whitespace-nowrap border-b border-neutral-300 p-2 font-light dark:border-neutral-700"}>
@@ -40,6 +75,9 @@ export default function Component(props: {
             <div className={`flex size-full flex-col overflow-auto px-2 py-1.5`}>
                 {
                     result.map((item, index) => {
+                        if (item.type === EVENT_IMAGE || item.type === EVENT_HTML || item.type === EVENT_TABLE) {
+                            return <Rich key={index} item={item}/>
+                        }
                         return (
                             <pre key={index}
                                  className={item.type === EVENT_STDERR ? errorColor : ""}>
@@ -12,6 +12,21 @@ export interface ExecuteResultI {
     stderr: string;
 }
 
+// how a run ended, sent as the result event
+export interface ExecutionResultI {
+    stage: "setup" | "build" | "run";
+    exit_code: number;
+    signal?: string;
+    timeout?: "wall" | "cpu";
+    oom_killed?: boolean;
+    wall_time_us: number;
+    cpu_user_us: number;
+    cpu_system_us: number;
+    peak_memory_bytes: number;
+    truncated?: boolean;
+    error?: string;
+}
+
 export interface SSEEvent {
     event: string;
     data: string;
@@ -67,17 +82,28 @@ export type languages =
     | "cs"
     | "sk"
 
-export type resultType = "stdout" | "stderr"
+export type resultType = "stdout" | "stderr" | "image" | "html" | "table"
 
 export type toastType = "info" | "error"
 
 export type selectableDrawers = "documentSymbols" | "stats" | "library" | ""
 
+// content is the text, the HTML fragment, or the JSON of the image or the table
 export interface resultI {
     type: resultType;
     content: string;
 }
 
+export interface imageI {
+    mime: string;
+    data: string; // base64
+}
+
+export interface tableI {
+    columns: string[];
+    rows: unknown[][];
+}
+
 export interface fetchSourceRes {
     content: string;
     error: string;
@@ -18,6 +18,8 @@ import {
     FONT_SIZE_KEY,
     HELLO_WORLD,
     IS_AUTOCOMPLETION_ON_KEY,
+    IS_NETWORK_ON_KEY,
+    DEFAULT_NETWORK_ON,
     IS_LINT_ON_KEY,
     IS_VERTICAL_LAYOUT_KEY,
     KEY_BINDINGS_KEY,
@@ -139,6 +141,10 @@ export function getAutoCompletionOn(): boolean {
     return JSON.parse(localStorage.getItem(IS_AUTOCOMPLETION_ON_KEY) || DEFAULT_AUTOCOMPLETION_ON)
 }
 
+export function getNetworkOn(): boolean {
+    return JSON.parse(localStorage.getItem(IS_NETWORK_ON_KEY) || DEFAULT_NETWORK_ON)
+}
+
 const apiUrl = import.meta.env.VITE_API_URL || "";
 const isDev = import.meta.env.MODE === "development";
 
@@ -301,3 +307,14 @@ export const pushSession = (sessions: SessionI[], session: SessionI) => {
 export const sleep = (ms: number) => {
     return new Promise((resolve) => setTimeout(resolve, ms))
 }
+
+// format a duration in microseconds the way Go prints a time.Duration, e.g. 1.5ms
+export const formatMicroseconds = (us: number): string => {
+    if (us >= 1000000) {
+        return `${us / 1000000}s`
+    }
+    if (us >= 1000) {
+        return `${us / 1000}ms`
+    }
+    return `${us}µs`
+}
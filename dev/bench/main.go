@@ -0,0 +1,140 @@
+// Command bench measures the latency of /execute against a running server.
+//
+// Time to first output is the time from sending the request until the first stdout event,
+// which is what a user perceives as the cold-start latency of a run.
+//
+//	go run ./dev/bench -url http://localhost:3000 -n 50 -c 2
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const helloWorld = `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("Hello, Go Sandbox!")
+}`
+
+type sample struct {
+	firstOutput time.Duration
+	total       time.Duration
+	err         error
+}
+
+func main() {
+	var (
+		url      = flag.String("url", "http://localhost:3000", "base url of the server")
+		n        = flag.Int("n", 20, "number of runs")
+		c        = flag.Int("c", 1, "concurrent runs")
+		codeFile = flag.String("code", "", "file of the program to run, hello world by default")
+		version  = flag.String("version", "", "go version")
+	)
+	flag.Parse()
+
+	code := helloWorld
+	if *codeFile != "" {
+		b, err := os.ReadFile(*codeFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		code = string(b)
+	}
+	body, err := json.Marshal(map[string]string{"code": code, "version": *version})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var (
+		samples = make([]sample, *n)
+		jobs    = make(chan int)
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < *c; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				samples[j] = measure(*url+"/execute", body)
+			}
+		}()
+	}
+	for i := range samples {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var first, total []time.Duration
+	for _, s := range samples {
+		if s.err != nil {
+			log.Printf("run failed: %s", s.err)
+			continue
+		}
+		first = append(first, s.firstOutput)
+		total = append(total, s.total)
+	}
+	fmt.Printf("runs: %d ok, %d failed\n", len(first), *n-len(first))
+	report("time to first output", first)
+	report("total", total)
+}
+
+func measure(url string, body []byte) sample {
+	start := time.Now()
+	res, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return sample{err: err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return sample{err: fmt.Errorf("status %s", res.Status)}
+	}
+
+	var (
+		s       sample
+		scanner = bufio.NewScanner(res.Body)
+	)
+	for scanner.Scan() {
+		event, ok := strings.CutPrefix(scanner.Text(), "event:")
+		if !ok {
+			continue
+		}
+		switch event {
+		case "stdout":
+			if s.firstOutput == 0 {
+				s.firstOutput = time.Since(start)
+			}
+		case "error":
+			s.err = fmt.Errorf("execution error")
+		}
+	}
+	s.total = time.Since(start)
+	if s.err == nil && s.firstOutput == 0 {
+		s.err = fmt.Errorf("no output")
+	}
+	return s
+}
+
+func report(name string, d []time.Duration) {
+	if len(d) == 0 {
+		return
+	}
+	slices.Sort(d)
+	pct := func(p float64) time.Duration {
+		return d[int(p*float64(len(d)-1))].Round(time.Millisecond)
+	}
+	fmt.Printf("%-22s p50 %-8v p90 %-8v p99 %-8v max %v\n", name+":", pct(0.5), pct(0.9), pct(0.99), d[len(d)-1].Round(time.Millisecond))
+}
@@ -0,0 +1,89 @@
+// Package display shows images, HTML and tables in the output of a program run in the Go Sandbox.
+//
+// Each of them is written to stdout as a line of its own, which the sandbox shows in place of the text:
+//
+//	SANDBOX_OUTPUT:{"type":"image","mime":"image/png","data":"<base64>"}
+//
+// Elsewhere, the lines are printed as they are.
+package display
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"sync"
+)
+
+const prefix = "SANDBOX_OUTPUT:"
+
+// Output is where the lines are written, stdout by default.
+var Output io.Writer = os.Stdout
+
+var lock sync.Mutex
+
+type message struct {
+	Type string `json:"type"`
+	MIME string `json:"mime,omitempty"`
+	Data any    `json:"data"`
+}
+
+// Table is a table of values, each row has a value per column.
+type Table struct {
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+// Image shows img as a PNG.
+func Image(img image.Image) error {
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return err
+	}
+	return PNG(b.Bytes())
+}
+
+// PNG shows an image encoded as PNG.
+func PNG(data []byte) error {
+	return write(message{Type: "image", MIME: "image/png", Data: base64.StdEncoding.EncodeToString(data)})
+}
+
+// SVG shows an SVG image, scripts in it do not run.
+func SVG(svg string) error {
+	return write(message{Type: "image", MIME: "image/svg+xml", Data: base64.StdEncoding.EncodeToString([]byte(svg))})
+}
+
+// HTML shows an HTML fragment, scripts in it do not run.
+func HTML(html string) error {
+	return write(message{Type: "html", Data: html})
+}
+
+// ShowTable shows a table with the columns and rows, each row has a value per column.
+func ShowTable(columns []string, rows [][]any) error {
+	if len(columns) == 0 {
+		return errors.New("display: a table needs columns")
+	}
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return fmt.Errorf("display: row %d has %d values for %d columns", i, len(row), len(columns))
+		}
+	}
+	return write(message{Type: "table", Data: Table{Columns: columns, Rows: rows}})
+}
+
+func write(m message) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	// a line of its own, even if the program has not ended its last line
+	_, err = fmt.Fprintf(Output, "\n%s%s\n", prefix, b)
+	return err
+}
@@ -0,0 +1,3 @@
+module github.com/tianqi-wen_frgr/go-sandbox/display
+
+go 1.21
@@ -0,0 +1,156 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/gorilla/websocket"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+)
+
+var (
+	validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+	ErrInvalidID   = errors.New("invalid session id")
+	ErrSessionFull = errors.New("session is full")
+)
+
+// RunFunc executes the code and reports every event of the execution to emit.
+type RunFunc func(ctx context.Context, session, code, version string, emit func(event string, data []byte))
+
+// Store persists the documents, it is the snippet store in production.
+type Store interface {
+	Load(ctx context.Context, key string) (string, error)
+	Save(ctx context.Context, code string) (string, error)
+}
+
+// Hub keeps track of the live editing sessions.
+type Hub struct {
+	run   RunFunc
+	store Store
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	nextID   int
+}
+
+func NewHub(run RunFunc, store Store) *Hub {
+	return &Hub{
+		run:      run,
+		store:    store,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Serve joins the connection to the session and blocks until the connection is closed.
+// A new session starts with the content of the snippet if given.
+func (h *Hub) Serve(ws *websocket.Conn, sessionID, name, snippet string) error {
+	if !validID.MatchString(sessionID) {
+		return ErrInvalidID
+	}
+
+	doc, err := h.initialDoc(sessionID, snippet)
+	if err != nil {
+		return err
+	}
+
+	// joining under the hub lock, so that a session being closed is never joined
+	h.mu.Lock()
+	s, ok := h.sessions[sessionID]
+	if !ok {
+		s = h.newSession(sessionID, doc)
+	}
+	h.nextID++
+	cl := &client{
+		id:   strconv.Itoa(h.nextID),
+		name: name,
+		ws:   ws,
+		out:  make(chan message, config.CollabSendBuffer),
+	}
+	err = s.join(cl)
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer h.leave(s, cl)
+
+	go cl.writeLoop()
+
+	ws.SetReadLimit(config.CollabMaxMessageSize)
+	for {
+		var data []byte
+		if _, data, err = ws.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("collab read error: %s", err)
+			}
+			return nil
+		}
+
+		var msg message
+		if err = json.Unmarshal(data, &msg); err != nil {
+			s.mu.Lock()
+			s.reply(cl, message{Type: typeError, Error: err.Error()})
+			s.mu.Unlock()
+			continue
+		}
+		s.handle(cl, msg)
+	}
+}
+
+// initialDoc loads the snippet for a session that does not exist yet.
+func (h *Hub) initialDoc(id, snippet string) (string, error) {
+	h.mu.Lock()
+	_, ok := h.sessions[id]
+	h.mu.Unlock()
+	if ok || snippet == "" {
+		return "", nil
+	}
+	return h.store.Load(context.Background(), snippet)
+}
+
+// newSession must be called with h.mu held.
+func (h *Hub) newSession(id, doc string) *Session {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Session{
+		id:      id,
+		hub:     h,
+		ctx:     ctx,
+		cancel:  cancel,
+		doc:     utf16.Encode([]rune(doc)),
+		clients: make(map[string]*client),
+	}
+	h.sessions[id] = s
+	return s
+}
+
+// leave removes the client, the last one closes the session and persists the document.
+func (h *Hub) leave(s *Session, cl *client) {
+	h.mu.Lock()
+	empty := s.leave(cl)
+	if empty {
+		delete(h.sessions, s.id)
+	}
+	h.mu.Unlock()
+
+	// nothing is delivered to the client once it left the session
+	close(cl.out)
+
+	if !empty {
+		return
+	}
+	s.cancel()
+	if code, dirty := s.snapshot(); dirty {
+		ctx, cancel := context.WithTimeout(context.Background(), config.APIGlobalTimeout*time.Second)
+		defer cancel()
+		if _, err := h.store.Save(ctx, code); err != nil {
+			log.Printf("failed to persist collab session %s: %s", s.id, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"unicode/utf16"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+)
+
+// The operations follow the wire format of ot.js: an array where a positive number retains,
+// a negative number deletes and a string inserts. Positions count UTF-16 code units like the editor does.
+
+var (
+	ErrBaseLength = errors.New("operation does not match the document length")
+	ErrMalformed  = errors.New("malformed operation")
+	ErrTooLarge   = errors.New("operation is larger than a document may be")
+)
+
+type component struct {
+	retain int
+	insert []uint16
+	delete int
+}
+
+// Operation is a sequence of retain, insert and delete components covering a whole document.
+type Operation struct {
+	ops       []component
+	baseLen   int
+	targetLen int
+}
+
+func (o *Operation) Retain(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLen += n
+	o.targetLen += n
+	if last := o.last(); last != nil && last.retain > 0 {
+		last.retain += n
+		return o
+	}
+	o.ops = append(o.ops, component{retain: n})
+	return o
+}
+
+func (o *Operation) Insert(s []uint16) *Operation {
+	if len(s) == 0 {
+		return o
+	}
+	o.targetLen += len(s)
+	last := o.last()
+	switch {
+	case last != nil && last.insert != nil:
+		last.insert = append(last.insert, s...)
+	case last != nil && last.delete > 0:
+		// keep inserts before deletes so that equal operations have one representation
+		if n := len(o.ops); n > 1 && o.ops[n-2].insert != nil {
+			o.ops[n-2].insert = append(o.ops[n-2].insert, s...)
+		} else {
+			o.ops = append(o.ops[:n-1], component{insert: append([]uint16(nil), s...)}, *last)
+		}
+	default:
+		o.ops = append(o.ops, component{insert: append([]uint16(nil), s...)})
+	}
+	return o
+}
+
+func (o *Operation) Delete(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLen += n
+	if last := o.last(); last != nil && last.delete > 0 {
+		last.delete += n
+		return o
+	}
+	o.ops = append(o.ops, component{delete: n})
+	return o
+}
+
+func (o *Operation) last() *component {
+	if len(o.ops) == 0 {
+		return nil
+	}
+	return &o.ops[len(o.ops)-1]
+}
+
+// Apply returns the document after the operation.
+func (o *Operation) Apply(doc []uint16) ([]uint16, error) {
+	if len(doc) != o.baseLen {
+		return nil, ErrBaseLength
+	}
+	out := make([]uint16, 0, o.targetLen)
+	i := 0
+	for _, c := range o.ops {
+		switch {
+		case c.retain > 0:
+			out = append(out, doc[i:i+c.retain]...)
+			i += c.retain
+		case c.insert != nil:
+			out = append(out, c.insert...)
+		default:
+			i += c.delete
+		}
+	}
+	return out, nil
+}
+
+// Transform takes two operations a and b made concurrently on the same document and returns
+// a' and b' such that applying a then b' equals applying b then a'. Inserts of a win ties.
+func Transform(a, b *Operation) (*Operation, *Operation, error) {
+	if a.baseLen != b.baseLen {
+		return nil, nil, ErrBaseLength
+	}
+	var (
+		a1, b1 = &Operation{}, &Operation{}
+		i, j   int
+		op1    = at(a.ops, i)
+		op2    = at(b.ops, j)
+	)
+	for op1 != nil || op2 != nil {
+		if op1 != nil && op1.insert != nil {
+			a1.Insert(op1.insert)
+			b1.Retain(len(op1.insert))
+			i++
+			op1 = at(a.ops, i)
+			continue
+		}
+		if op2 != nil && op2.insert != nil {
+			a1.Retain(len(op2.insert))
+			b1.Insert(op2.insert)
+			j++
+			op2 = at(b.ops, j)
+			continue
+		}
+		if op1 == nil || op2 == nil {
+			return nil, nil, ErrMalformed
+		}
+
+		n := min(op1.len(), op2.len())
+		switch {
+		case op1.retain > 0 && op2.retain > 0:
+			a1.Retain(n)
+			b1.Retain(n)
+		case op1.delete > 0 && op2.retain > 0:
+			a1.Delete(n)
+		case op1.retain > 0 && op2.delete > 0:
+			b1.Delete(n)
+		}
+		// both deleting the same range needs no output
+
+		if op1, i = shrink(a.ops, op1, i, n); op1 == nil {
+			op1 = at(a.ops, i)
+		}
+		if op2, j = shrink(b.ops, op2, j, n); op2 == nil {
+			op2 = at(b.ops, j)
+		}
+	}
+	return a1, b1, nil
+}
+
+// TransformIndex moves a cursor position through the operation.
+func (o *Operation) TransformIndex(pos int) int {
+	var index, out = 0, pos
+	for _, c := range o.ops {
+		if index > pos {
+			break
+		}
+		switch {
+		case c.retain > 0:
+			index += c.retain
+		case c.insert != nil:
+			out += len(c.insert)
+		default:
+			out -= min(pos-index, c.delete)
+			index += c.delete
+		}
+	}
+	return out
+}
+
+func (c *component) len() int {
+	if c.retain > 0 {
+		return c.retain
+	}
+	return c.delete
+}
+
+func at(ops []component, i int) *component {
+	if i >= len(ops) {
+		return nil
+	}
+	c := ops[i]
+	return &c
+}
+
+// shrink consumes n units of a retain or delete, returns nil when it is used up so the caller moves on.
+func shrink(ops []component, c *component, i, n int) (*component, int) {
+	if c.len() == n {
+		return nil, i + 1
+	}
+	if c.retain > 0 {
+		c.retain -= n
+	} else {
+		c.delete -= n
+	}
+	return c, i
+}
+
+func (o *Operation) MarshalJSON() ([]byte, error) {
+	out := make([]any, 0, len(o.ops))
+	for _, c := range o.ops {
+		switch {
+		case c.retain > 0:
+			out = append(out, c.retain)
+		case c.insert != nil:
+			out = append(out, string(utf16.Decode(c.insert)))
+		default:
+			out = append(out, -c.delete)
+		}
+	}
+	return json.Marshal(out)
+}
+
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*o = Operation{}
+	for _, r := range raw {
+		var (
+			n int
+			s string
+		)
+		if err := json.Unmarshal(r, &n); err == nil {
+			if n == 0 {
+				return ErrMalformed
+			}
+			// no document is longer, and the lengths cannot overflow
+			if n > config.CollabMaxDocSize || n < -config.CollabMaxDocSize || o.baseLen+max(n, -n) > config.CollabMaxDocSize {
+				return ErrTooLarge
+			}
+			if n > 0 {
+				o.Retain(n)
+			} else {
+				o.Delete(-n)
+			}
+			continue
+		}
+		if err := json.Unmarshal(r, &s); err != nil || s == "" {
+			return ErrMalformed
+		}
+		o.Insert(utf16.Encode([]rune(s)))
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package collab
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/gorilla/websocket"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+)
+
+// message types
+const (
+	typeInit   = "init"
+	typeOp     = "op"
+	typeAck    = "ack"
+	typeCursor = "cursor"
+	typeJoin   = "join"
+	typeLeave  = "leave"
+	typeRun    = "run"
+	typeEvent  = "event"
+	typeSave   = "save"
+	typeSaved  = "saved"
+	typeError  = "error"
+)
+
+// Cursor is the selection of a client, Anchor equals Pos when nothing is selected.
+type Cursor struct {
+	Pos    int `json:"pos"`
+	Anchor int `json:"anchor"`
+}
+
+type peer struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name,omitempty"`
+	Cursor *Cursor `json:"cursor,omitempty"`
+}
+
+// message is the envelope of everything sent over the websocket in both directions.
+type message struct {
+	Type    string     `json:"type"`
+	Rev     int        `json:"rev"`
+	Op      *Operation `json:"op,omitempty"`
+	Cursor  *Cursor    `json:"cursor,omitempty"`
+	Client  string     `json:"client,omitempty"`
+	Clients []peer     `json:"clients,omitempty"`
+	Doc     *string    `json:"doc,omitempty"`
+	Version string     `json:"version,omitempty"`
+	Event   string     `json:"event,omitempty"`
+	Data    string     `json:"data,omitempty"`
+	Key     string     `json:"key,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+type client struct {
+	id     string
+	name   string
+	cursor *Cursor
+	ws     *websocket.Conn
+	out    chan message
+}
+
+func (cl *client) writeLoop() {
+	for msg := range cl.out {
+		_ = cl.ws.SetWriteDeadline(time.Now().Add(config.APIGlobalTimeout * time.Second))
+		if err := cl.ws.WriteJSON(msg); err != nil {
+			log.Printf("collab write error: %s", err)
+			// unblock the read loop, the client leaves from there
+			cl.ws.Close()
+			for range cl.out {
+			}
+			return
+		}
+	}
+}
+
+// Session is one shared document, the server is the single source of truth of its revisions.
+type Session struct {
+	id     string
+	hub    *Hub
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	doc     []uint16
+	history []*Operation // history[i] turns revision base+i into base+i+1
+	base    int          // the oldest revision an operation can be based on, the older operations are dropped
+	clients map[string]*client
+	running bool
+	dirty   bool
+}
+
+func (s *Session) join(cl *client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.clients) >= config.CollabMaxClients {
+		return ErrSessionFull
+	}
+
+	doc := string(utf16.Decode(s.doc))
+	init := message{Type: typeInit, Rev: s.rev(), Client: cl.id, Doc: &doc}
+	for _, other := range s.clients {
+		init.Clients = append(init.Clients, peer{ID: other.id, Name: other.name, Cursor: other.cursor})
+	}
+	s.broadcast(message{Type: typeJoin, Clients: []peer{{ID: cl.id, Name: cl.name}}})
+
+	s.clients[cl.id] = cl
+	cl.out <- init
+	return nil
+}
+
+// leave reports whether the session is empty afterward.
+func (s *Session) leave(cl *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.clients, cl.id)
+	s.broadcast(message{Type: typeLeave, Client: cl.id})
+	return len(s.clients) == 0
+}
+
+func (s *Session) snapshot() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return string(utf16.Decode(s.doc)), s.dirty
+}
+
+func (s *Session) handle(cl *client, msg message) {
+	switch msg.Type {
+	case typeOp:
+		s.applyOp(cl, msg)
+	case typeCursor:
+		if msg.Cursor == nil {
+			return
+		}
+		s.mu.Lock()
+		cl.cursor = msg.Cursor
+		s.broadcastExcept(cl, message{Type: typeCursor, Client: cl.id, Cursor: msg.Cursor})
+		s.mu.Unlock()
+	case typeRun:
+		s.run(cl, msg.Version)
+	case typeSave:
+		go s.save(cl)
+	default:
+		s.reply(cl, message{Type: typeError, Error: "unknown message type"})
+	}
+}
+
+// applyOp transforms the operation against everything the client has not seen yet, then applies it.
+func (s *Session) applyOp(cl *client, msg message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg.Op == nil || msg.Rev < 0 || msg.Rev > s.rev() {
+		s.reply(cl, message{Type: typeError, Error: "invalid revision"})
+		return
+	}
+	if msg.Rev < s.base {
+		s.reply(cl, message{Type: typeError, Error: "the revision is too old, join the session again"})
+		return
+	}
+
+	op := msg.Op
+	for _, concurrent := range s.history[msg.Rev-s.base:] {
+		var err error
+		if op, _, err = Transform(op, concurrent); err != nil {
+			s.reply(cl, message{Type: typeError, Error: err.Error()})
+			return
+		}
+	}
+
+	doc, err := op.Apply(s.doc)
+	if err != nil {
+		s.reply(cl, message{Type: typeError, Error: err.Error()})
+		return
+	}
+	if len(doc) > config.CollabMaxDocSize {
+		s.reply(cl, message{Type: typeError, Error: "document is too large"})
+		return
+	}
+
+	s.doc = doc
+	s.dirty = true
+	s.history = append(s.history, op)
+	if len(s.history) > config.CollabMaxHistory {
+		// the slice is reallocated as it grows, which frees the dropped ones
+		s.history[0] = nil
+		s.history = s.history[1:]
+		s.base++
+	}
+	for _, other := range s.clients {
+		if other != cl && other.cursor != nil {
+			other.cursor = &Cursor{Pos: op.TransformIndex(other.cursor.Pos), Anchor: op.TransformIndex(other.cursor.Anchor)}
+		}
+	}
+
+	rev := s.rev()
+	s.reply(cl, message{Type: typeAck, Rev: rev})
+	s.broadcastExcept(cl, message{Type: typeOp, Rev: rev, Client: cl.id, Op: op})
+}
+
+// run executes the current document, every client receives the output. Only one run at a time.
+func (s *Session) run(cl *client, version string) {
+	s.mu.Lock()
+	if s.running {
+		s.reply(cl, message{Type: typeError, Error: "the session is already running"})
+		s.mu.Unlock()
+		return
+	}
+	s.running = true
+	code := string(utf16.Decode(s.doc))
+	s.broadcast(message{Type: typeRun, Rev: s.rev(), Client: cl.id, Version: version})
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			s.running = false
+			s.mu.Unlock()
+		}()
+
+		s.hub.run(s.ctx, s.id, code, version, func(event string, data []byte) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.broadcast(message{Type: typeEvent, Event: event, Data: string(data)})
+		})
+	}()
+}
+
+func (s *Session) save(cl *client) {
+	code, _ := s.snapshot()
+
+	ctx, cancel := context.WithTimeout(s.ctx, config.APIGlobalTimeout*time.Second)
+	defer cancel()
+
+	key, err := s.hub.store.Save(ctx, code)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.reply(cl, message{Type: typeError, Error: err.Error()})
+		return
+	}
+	s.dirty = false
+	s.broadcast(message{Type: typeSaved, Client: cl.id, Key: key})
+}
+
+// the methods below must be called with s.mu held
+
+// rev returns the revision of the document.
+func (s *Session) rev() int {
+	return s.base + len(s.history)
+}
+
+func (s *Session) reply(cl *client, msg message) {
+	if _, ok := s.clients[cl.id]; ok {
+		s.deliver(cl, msg)
+	}
+}
+
+func (s *Session) broadcast(msg message) {
+	for _, cl := range s.clients {
+		s.deliver(cl, msg)
+	}
+}
+
+func (s *Session) broadcastExcept(except *client, msg message) {
+	for _, cl := range s.clients {
+		if cl != except {
+			s.deliver(cl, msg)
+		}
+	}
+}
+
+// deliver never blocks the session, a client that cannot keep up is disconnected.
+func (s *Session) deliver(cl *client, msg message) {
+	select {
+	case cl.out <- msg:
+	default:
+		log.Printf("collab client %s is too slow, disconnecting", cl.id)
+		cl.ws.Close()
+	}
+}
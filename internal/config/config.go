@@ -2,18 +2,135 @@ package config
 
 // env keys
 const (
-	EnvKey       = "GIN_MODE"
-	AwsRegionKey = "AWS_REGION"
+	EnvKey        = "GIN_MODE"
+	AwsRegionKey  = "AWS_REGION"
+	GoModCacheKey = "GOMODCACHE"
+
+	ExecuteMaxConcurrentKey   = "EXECUTE_MAX_CONCURRENT"
+	ExecuteMaxQueuedKey       = "EXECUTE_MAX_QUEUED"
+	ExecuteMaxQueuedClientKey = "EXECUTE_MAX_QUEUED_PER_CLIENT"
+	ExecuteMaxOutputBytesKey  = "EXECUTE_MAX_OUTPUT_BYTES"
+	ExecuteMaxOutputLinesKey  = "EXECUTE_MAX_OUTPUT_LINES"
+	ExecuteBackendKey         = "EXECUTE_BACKEND"
+	WasmMemoryLimitKey        = "WASM_MEMORY_LIMIT"
+	WasmMaxCallsKey           = "WASM_MAX_CALLS"
+	SandboxTempDirKey         = "SANDBOX_TEMP_DIR"
+	DockerSocketKey           = "DOCKER_SOCKET"
+	DockerImageKey            = "DOCKER_IMAGE"
+	DockerWorkDirKey          = "DOCKER_WORK_DIR"
+	ModProxyAddrKey           = "MODPROXY_ADDR"
+	RunnersKey                = "RUNNERS"
+	RunnerTokenKey            = "RUNNER_TOKEN"
 )
 
 const (
 	WorkspacePath       = "/app"
-	APIGlobalTimeout    = 10 // seconds
-	SandboxCPUTimeLimit = 5  // seconds
+	SandboxPath         = "/app/sandboxes/go" // where the user code lives
+	APIGlobalTimeout    = 10                  // seconds
+	SandboxCPUTimeLimit = 5                   // seconds
 	CodeSnippetBucket   = "go-sandbox-snippets"
 	ApiServerPort       = ":3000"
 	LocalStackEndpoint  = "http://localstack:4566"
 	DefaultRegion       = "ap-northeast-1"
 	ProdModeValue       = "release"
-	ExecuteMaxEvents    = 10000 // max events to send to the client
+	DefaultGoVersion    = "1"
+	ModProxyPath        = "./modproxy"     // the modules allowed in the sandbox
+	ModProxyAddr        = "127.0.0.1:3001" // only reachable by the sandbox builds, overridable by the env key above
+	PlaybackMaxDelay    = 5                // seconds, the longest pause replayed of a deterministic run
+)
+
+// execution scheduling defaults, overridable by the env keys above
+const (
+	ExecuteMaxConcurrent   = 2  // matches the CPU limit of the container
+	ExecuteMaxQueued       = 20 // in total
+	ExecuteMaxQueuedClient = 2  // per client
+	ExecuteRetryAfter      = 5  // seconds, suggested to the client when the queue is full
+	WorkspacePoolSize      = 4  // pre-initialized workspaces per Go version
+)
+
+// output limits of a run, stdout and stderr together, overridable by the env keys above
+const (
+	ExecuteMaxOutputBytes = 1024 * 1024
+	ExecuteMaxOutputLines = 10000
+)
+
+// the backend a program runs on unless the request names one, native, wasm or docker, and the limits of a wasm program
+const (
+	DefaultBackend  = "native"
+	WasmMemoryLimit = 256 * 1024 * 1024 // bytes
+	WasmMaxCalls    = 50_000_000        // function calls, about the CPU time limit of a program that calls a lot
+)
+
+// the docker backend, overridable by the env keys above. The daemon has to see the work directory at the same path.
+const (
+	DockerSocket  = "/var/run/docker.sock"
+	DockerImage   = "golang:%s-alpine" // of the Go version
+	DockerWorkDir = "/tmp/go-sandbox"
+)
+
+// the events of an execution are kept for clients that reconnect with Last-Event-ID
+const (
+	ExecutionBufferSize  = 1024 // events per execution, the older ones are dropped
+	ExecutionGracePeriod = 60   // seconds, a run nobody follows is stopped after it, and an ended run is dropped
+)
+
+// the builds and runs go to the runners listed in RUNNERS, host:port separated by commas, if any.
+// The API servers and the runners share RUNNER_TOKEN, a runner refuses the requests without it.
+const (
+	RunnerAddr           = "127.0.0.1:3100" // of the runner command, loopback unless -addr names another interface
+	RunnerHealthInterval = 5                // seconds
+)
+
+// the largest artifact of a run, a profile or a trace, that is read, a larger one is reported as too large
+const ArtifactMaxSize = 32 * 1024 * 1024 // bytes
+
+// the functions of a profile listed by the pprof event, by the time or memory they take
+const PprofTopN = 20
+
+// the most of the timeline of a trace sent by the trace event, the rest is left out
+const (
+	TraceMaxSpans  = 20000 // states of the goroutines
+	TraceMaxBlocks = 2000  // times a goroutine was waiting
+)
+
+// jobs are runs started with POST /executions and polled for their output
+const (
+	JobRetention    = 3600 // seconds, a job is dropped after it has not changed for this long
+	JobSaveInterval = 1    // seconds, the output of a running job is saved at most this often
+)
+
+// consecutive lines of output are sent to the client in batches
+const (
+	ExecuteFlushInterval = 20        // milliseconds, the longest a line waits in a batch
+	ExecuteBatchSize     = 32 * 1024 // bytes, a larger batch is sent at once
+)
+
+// seccomp profiles of the sandbox runner selectable per run, see sandbox/profiles
+const (
+	DefaultProfile         = "default"
+	NetworkLoopbackProfile = "network-loopback"
+)
+
+var SandboxProfiles = map[string]bool{
+	"strict":               true,
+	DefaultProfile:         true,
+	NetworkLoopbackProfile: true,
+}
+
+// the targets a program can be compiled for, GOARCH is wasm
+var WasmTargets = map[string]bool{"js": true, "wasip1": true}
+
+// GoRoots maps the Go versions selectable by the client to the GOROOT of the toolchain, each version needs
+// its own toolchain installed there: the image only has the default one
+var GoRoots = map[string]string{
+	"1": "/go",
+}
+
+// collaborative editing
+const (
+	CollabMaxClients     = 16      // per session
+	CollabMaxDocSize     = 1 << 16 // UTF-16 code units
+	CollabMaxMessageSize = 1 << 18 // bytes
+	CollabSendBuffer     = 256     // messages queued per client
+	CollabMaxHistory     = 1024    // operations kept per session, a client further behind has to join again
 )
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Int reads a positive integer from the environment, def is used if it is unset or invalid.
+func Int(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("invalid value of %s: %q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// PositiveInt reads an integer from the environment like Int, but 0 is not valid either.
+func PositiveInt(key string, def int) int {
+	n := Int(key, def)
+	if n == 0 {
+		log.Printf("invalid value of %s: 0, using %d", key, def)
+		return def
+	}
+	return n
+}
+
+// String reads a string from the environment, def is used if it is unset.
+func String(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// List returns the comma-separated values of the env key, none if unset.
+func List(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,207 @@
+package execution
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Event is an event of an execution, IDs start at 1 and have no gaps.
+type Event struct {
+	ID   uint64
+	Name string
+	Data []byte
+}
+
+// Execution keeps the latest events of a run in a ring buffer, so that a client can follow it from
+// where it left off. The run goes on while nobody follows it, its context is canceled once nobody has
+// for the grace period.
+type Execution struct {
+	ID string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	grace  time.Duration
+
+	mu        sync.Mutex
+	events    []Event // ring buffer, the oldest event is at start
+	start     int
+	next      uint64        // the ID of the next event
+	done      bool          // no more events come
+	canceled  bool          // by Cancel, before the run ended
+	changed   chan struct{} // closed and replaced on every new event
+	followers int
+	idle      *time.Timer // cancels the run when nobody follows it
+}
+
+func newExecution(id string, capacity int, grace time.Duration) *Execution {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Execution{
+		ID:      id,
+		ctx:     ctx,
+		cancel:  cancel,
+		grace:   grace,
+		events:  make([]Event, 0, capacity),
+		next:    1,
+		changed: make(chan struct{}),
+	}
+	// the client that started it may never follow it
+	e.idle = time.AfterFunc(grace, cancel)
+	return e
+}
+
+// Context is canceled when nobody has followed the execution for the grace period, or by Cancel.
+func (e *Execution) Context() context.Context {
+	return e.ctx
+}
+
+// Cancel cancels the context of the run, unless it has ended.
+func (e *Execution) Cancel() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done {
+		return
+	}
+	e.canceled = true
+	e.cancel()
+}
+
+// Canceled reports whether Cancel was called before the run ended.
+func (e *Execution) Canceled() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.canceled
+}
+
+// Send adds an event, the oldest one is dropped if the buffer is full. data is copied.
+func (e *Execution) Send(name string, data []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done {
+		return
+	}
+
+	ev := Event{ID: e.next, Name: name, Data: append([]byte(nil), data...)}
+	e.next++
+	if len(e.events) < cap(e.events) {
+		e.events = append(e.events, ev)
+	} else {
+		e.events[e.start] = ev
+		e.start = (e.start + 1) % len(e.events)
+	}
+	e.notify()
+}
+
+// Close marks the end of the events.
+func (e *Execution) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done {
+		return
+	}
+	e.done = true
+	e.idle.Stop()
+	e.cancel()
+	e.notify()
+}
+
+// Events returns the buffered events after the ID, and how many of them have been dropped from the buffer.
+// If there are none and more may come, changed is closed once there are.
+func (e *Execution) Events(after uint64) (events []Event, missed uint64, done bool, changed <-chan struct{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range e.events {
+		ev := e.events[(e.start+i)%len(e.events)]
+		if ev.ID > after {
+			events = append(events, ev)
+		}
+	}
+	if len(events) > 0 && events[0].ID > after+1 {
+		missed = events[0].ID - after - 1
+	}
+	return events, missed, e.done, e.changed
+}
+
+// Follow counts a follower until the returned function is called.
+func (e *Execution) Follow() (unfollow func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.followers++
+	e.idle.Stop()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			e.followers--
+			if e.followers == 0 && !e.done {
+				e.idle.Reset(e.grace)
+			}
+		})
+	}
+}
+
+// notify must be called with the lock held.
+func (e *Execution) notify() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// Registry keeps the executions until the grace period after they end.
+type Registry struct {
+	capacity int
+	grace    time.Duration
+
+	mu         sync.Mutex
+	executions map[string]*Execution
+}
+
+// NewRegistry returns a registry of executions that keep capacity events each.
+func NewRegistry(capacity int, grace time.Duration) *Registry {
+	return &Registry{
+		capacity:   max(capacity, 1),
+		grace:      grace,
+		executions: make(map[string]*Execution),
+	}
+}
+
+// Start registers a new execution and calls run with it in a goroutine, the execution is closed when it returns.
+func (r *Registry) Start(run func(e *Execution)) *Execution {
+	e := newExecution(newID(), r.capacity, r.grace)
+
+	r.mu.Lock()
+	r.executions[e.ID] = e
+	r.mu.Unlock()
+
+	go func() {
+		defer func() {
+			e.Close()
+			time.AfterFunc(r.grace, func() {
+				r.mu.Lock()
+				delete(r.executions, e.ID)
+				r.mu.Unlock()
+			})
+		}()
+		run(e)
+	}()
+	return e
+}
+
+// Get returns the execution of the ID, if it is kept.
+func (r *Registry) Get(id string) (*Execution, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.executions[id]
+	return e, ok
+}
+
+// newID returns an ID that cannot be guessed, it is all it takes to follow an execution.
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
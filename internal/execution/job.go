@@ -0,0 +1,95 @@
+package execution
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/protocol"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// the status of a job
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobDone     = "done"   // the program ran and exited with 0
+	JobFailed   = "failed" // it did not build, did not exit with 0 or could not be run
+	JobCanceled = "canceled"
+)
+
+// Job is the state of an execution started without a client following it, the whole output is kept.
+type Job struct {
+	ID       string           `json:"id"`
+	Status   string           `json:"status"`
+	Created  time.Time        `json:"created"`
+	Started  *time.Time       `json:"started,omitempty"`
+	Finished *time.Time       `json:"finished,omitempty"`
+	Stdout   string           `json:"stdout"`
+	Stderr   string           `json:"stderr"`
+	Result   *protocol.Result `json:"result,omitempty"`
+	Error    string           `json:"error,omitempty"`
+	Pprof    json.RawMessage  `json:"pprof,omitempty"` // the summaries of the profiles of the run, if asked for
+	Trace    json.RawMessage  `json:"trace,omitempty"` // the timeline of the trace of the run, if asked for
+}
+
+// Ended reports whether the status of the job is final.
+func (j *Job) Ended() bool {
+	return j.Status == JobDone || j.Status == JobFailed || j.Status == JobCanceled
+}
+
+// Store keeps the jobs, Put is called whenever a job changes.
+type Store interface {
+	Put(ctx context.Context, job Job) error
+	// Get returns ErrJobNotFound if there is no job of the ID
+	Get(ctx context.Context, id string) (Job, error)
+}
+
+// MemoryStore keeps the jobs in memory, for ttl after they last changed.
+type MemoryStore struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	jobs  map[string]memoryJob
+	swept time.Time // when the expired jobs were last dropped
+}
+
+type memoryJob struct {
+	job     Job
+	expires time.Time
+}
+
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{ttl: ttl, jobs: make(map[string]memoryJob)}
+}
+
+func (s *MemoryStore) Put(_ context.Context, job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.swept) > s.ttl {
+		for id, j := range s.jobs {
+			if now.After(j.expires) {
+				delete(s.jobs, id)
+			}
+		}
+		s.swept = now
+	}
+	s.jobs[job.ID] = memoryJob{job: job, expires: now.Add(s.ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Get(_ context.Context, id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok || time.Now().After(j.expires) {
+		return Job{}, ErrJobNotFound
+	}
+	return j.job, nil
+}
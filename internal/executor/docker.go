@@ -0,0 +1,343 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/protocol"
+)
+
+const (
+	dockerAPI         = "http://docker/v1.41" // the host is not used, the socket is
+	dockerCacheVolume = "go-sandbox-cache"    // the build cache shared by the build containers
+	dockerMemoryLimit = 2 * 1024 * 1024 * 1024
+	dockerPidsLimit   = 64
+	dockerNanoCPUs    = 1e9 // one CPU
+	dockerUser        = "65534:65534"
+	dockerKilledCode  = 137 // 128 + SIGKILL
+)
+
+// Docker builds and runs every program in containers of its own, of the golang image of the Go version,
+// through the Docker API on a local socket. The program runs without network, capabilities or a writable
+// filesystem but /tmp, as nobody. There is no module proxy, so only the standard library can be imported,
+// and there are no profiles or traces.
+type Docker struct {
+	client *http.Client
+	image  string // with a %s for the Go version
+	dir    string // of the workspaces, the daemon has to see it at the same path
+}
+
+// NewDocker returns an executor of the Docker daemon listening on the socket.
+// image is the name of the image with a %s for the Go version, such as golang:%s-alpine.
+func NewDocker(socket, image, dir string) *Docker {
+	return &Docker{
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}},
+		image: image,
+		dir:   dir,
+	}
+}
+
+func (d *Docker) Prepare(_ context.Context, version, code string) (*Workspace, error) {
+	if version == "" {
+		version = config.DefaultGoVersion
+	}
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return nil, fmt.Errorf("Failed to get a workspace: %v", err)
+	}
+	dir, err := os.MkdirTemp(d.dir, "ws-")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get a workspace: %v", err)
+	}
+	ws := &Workspace{Dir: dir, Version: version, release: func() { os.RemoveAll(dir) }}
+	// the program runs as nobody
+	err = os.Chmod(dir, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, codeFileName), []byte(code), 0644)
+	}
+	if err != nil {
+		ws.Release()
+		return nil, fmt.Errorf("Failed to write code file: %v", err)
+	}
+	return ws, nil
+}
+
+func (d *Docker) Build(ctx context.Context, ws *Workspace, target string, w io.Writer) ([]byte, *protocol.Result, error) {
+	res, err := d.build(ctx, ws, w, []string{"GOOS=" + target, "GOARCH=wasm"}, "-trimpath", "-o", moduleFileName)
+	if err != nil || !res.Compiled() {
+		return nil, res, err
+	}
+	module, err := os.ReadFile(filepath.Join(ws.Dir, moduleFileName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read the module: %v", err)
+	}
+	return module, res, nil
+}
+
+func (d *Docker) Run(_ context.Context, ws *Workspace, opts Options) (Process, error) {
+	if opts.Pprof || opts.Trace {
+		return nil, ErrNotSupported
+	}
+	args := []string{"-o", "prog"}
+	var env []string
+	if opts.Deterministic {
+		// like the sandbox runner does, see sandbox/main.go
+		args = append(args, "-tags=faketime")
+		env = append(env, "GODEBUG=randautoseed=0")
+	}
+	if opts.Term != "" {
+		env = append(env, "TERM="+opts.Term)
+	}
+
+	return goProcess(func(ctx context.Context, stdout, stderr io.Writer) (*protocol.Result, error) {
+		res, err := d.build(ctx, ws, stderr, nil, args...)
+		if err != nil || !res.Compiled() {
+			return res, err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, config.SandboxCPUTimeLimit*time.Second)
+		defer cancel()
+		start := time.Now()
+		exit, err := d.run(ctx, containerConfig{
+			Image:      d.imageOf(ws),
+			Cmd:        []string{"/src/prog"},
+			Env:        env,
+			WorkingDir: "/tmp",
+			User:       dockerUser,
+			HostConfig: hostConfig{
+				Binds:          []string{ws.Dir + ":/src:ro"},
+				NetworkMode:    "none",
+				Memory:         dockerMemoryLimit,
+				MemorySwap:     dockerMemoryLimit,
+				NanoCPUs:       dockerNanoCPUs,
+				PidsLimit:      dockerPidsLimit,
+				ReadonlyRootfs: true,
+				Tmpfs:          map[string]string{"/tmp": "size=64m"},
+				CapDrop:        []string{"ALL"},
+				SecurityOpt:    []string{"no-new-privileges"},
+			},
+		}, stdout, stderr)
+		if err != nil {
+			return nil, err
+		}
+
+		res = &protocol.Result{
+			Stage:     protocol.StageRun,
+			ExitCode:  exit.StatusCode,
+			OOMKilled: exit.OOMKilled,
+			WallTime:  time.Since(start).Microseconds(),
+		}
+		if exit.StatusCode == dockerKilledCode {
+			res.ExitCode, res.Signal = -1, "killed"
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			res.Timeout = protocol.TimeoutWall
+		}
+		return res, nil
+	}), nil
+}
+
+// build builds the program in the workspace with the go build arguments, the output goes to w.
+func (d *Docker) build(ctx context.Context, ws *Workspace, w io.Writer, env []string, args ...string) (*protocol.Result, error) {
+	// the workspace is not a module yet
+	script := "(test -f go.mod || go mod init sandbox >/dev/null 2>&1) && go build " + strings.Join(args, " ") + " ."
+	start := time.Now()
+	exit, err := d.run(ctx, containerConfig{
+		Image:      d.imageOf(ws),
+		Cmd:        []string{"sh", "-c", script},
+		Env:        append([]string{"CGO_ENABLED=0", "GOFLAGS=-mod=mod", "GOPROXY=off", "GOTOOLCHAIN=local"}, env...),
+		WorkingDir: "/src",
+		HostConfig: hostConfig{
+			Binds:       []string{ws.Dir + ":/src", dockerCacheVolume + ":/root/.cache/go-build"},
+			NetworkMode: "none",
+			Memory:      dockerMemoryLimit,
+			MemorySwap:  dockerMemoryLimit,
+			PidsLimit:   dockerPidsLimit * 4,
+		},
+	}, w, w)
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.Result{
+		Stage:    protocol.StageBuild,
+		ExitCode: exit.StatusCode,
+		WallTime: time.Since(start).Microseconds(),
+	}, nil
+}
+
+func (d *Docker) imageOf(ws *Workspace) string {
+	return fmt.Sprintf(d.image, ws.Version)
+}
+
+type containerConfig struct {
+	Image      string
+	Cmd        []string
+	Env        []string
+	WorkingDir string
+	User       string `json:",omitempty"`
+	HostConfig hostConfig
+}
+
+type hostConfig struct {
+	Binds          []string
+	NetworkMode    string
+	Memory         int64
+	MemorySwap     int64
+	NanoCPUs       int64 `json:"NanoCpus,omitempty"`
+	PidsLimit      int64
+	ReadonlyRootfs bool
+	Tmpfs          map[string]string `json:",omitempty"`
+	CapDrop        []string          `json:",omitempty"`
+	SecurityOpt    []string          `json:",omitempty"`
+}
+
+type containerExit struct {
+	StatusCode int
+	OOMKilled  bool
+}
+
+// run runs a container until it exits, it is killed once ctx is done. Its output is written to stdout and stderr.
+func (d *Docker) run(ctx context.Context, cfg containerConfig, stdout, stderr io.Writer) (containerExit, error) {
+	var created struct{ ID string }
+	if err := d.call(ctx, http.MethodPost, "/containers/create", cfg, &created); err != nil {
+		return containerExit{}, err
+	}
+	id := url.PathEscape(created.ID)
+	// the context may be done, the container is removed regardless
+	defer d.call(context.Background(), http.MethodDelete, "/containers/"+id+"?force=1", nil, nil)
+
+	if err := d.call(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil); err != nil {
+		return containerExit{}, err
+	}
+	// the logs end when the container exits
+	logs, err := d.stream(context.Background(), "/containers/"+id+"/logs?follow=1&stdout=1&stderr=1")
+	if err != nil {
+		return containerExit{}, err
+	}
+	defer logs.Close()
+	copied := make(chan error, 1)
+	go func() {
+		copied <- demux(logs, stdout, stderr)
+	}()
+
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = d.call(context.Background(), http.MethodPost, "/containers/"+id+"/kill", nil, nil)
+		case <-exited:
+		}
+	}()
+	var exit containerExit
+	err = d.call(context.Background(), http.MethodPost, "/containers/"+id+"/wait", nil, &exit)
+	close(exited)
+	if err != nil {
+		return exit, err
+	}
+	if err = <-copied; err != nil {
+		return exit, err
+	}
+
+	var inspect struct{ State containerExit }
+	if err = d.call(context.Background(), http.MethodGet, "/containers/"+id+"/json", nil, &inspect); err != nil {
+		return exit, err
+	}
+	exit.OOMKilled = inspect.State.OOMKilled
+	return exit, nil
+}
+
+// call calls the API with in as the JSON body if not nil, the response is decoded into out if not nil.
+func (d *Docker) call(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, dockerAPI+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("docker: %w", err)
+	}
+	defer res.Body.Close()
+	if err = dockerError(res); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// stream returns the body of a GET of the API.
+func (d *Docker) stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dockerAPI+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker: %w", err)
+	}
+	if err = dockerError(res); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func dockerError(res *http.Response) error {
+	if res.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+	var msg struct{ Message string }
+	if err := json.NewDecoder(res.Body).Decode(&msg); err != nil || msg.Message == "" {
+		return fmt.Errorf("docker: %s", res.Status)
+	}
+	return fmt.Errorf("docker: %s", msg.Message)
+}
+
+// demux copies the output of a container without a TTY, which comes in frames of either stream, to stdout and stderr.
+func demux(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte // the stream, 3 bytes of padding and the length of the frame
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		if _, err := io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package executor builds and runs the programs of the sandbox. An Executor is a way of isolating them:
+// Local runs them with the sandbox runner, Wasm in a WebAssembly runtime in the server and Docker in a container
+// per run.
+package executor
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/protocol"
+)
+
+var ErrNotSupported = errors.New("not supported by the executor")
+
+// the artifacts of a run: with Options.Pprof, profiles in the gzipped protobuf format of pprof,
+// and with Options.Trace, an execution trace of runtime/trace
+const (
+	CPUProfile  = "cpu.pprof"
+	HeapProfile = "heap.pprof"
+	Trace       = "trace.out"
+)
+
+// Artifact is a file of a run besides its output. One larger than config.ArtifactMaxSize is not read, it has no data.
+type Artifact struct {
+	Data []byte `json:"data,omitempty"`
+	Size int64  `json:"size"` // bytes
+}
+
+// TooLarge reports whether the artifact was not read for its size.
+func (a Artifact) TooLarge() bool {
+	return a.Size > config.ArtifactMaxSize
+}
+
+// Executor builds and runs programs.
+type Executor interface {
+	// Prepare returns a workspace with the code as the main package of a module of the Go version,
+	// it has to be released.
+	Prepare(ctx context.Context, version, code string) (*Workspace, error)
+	// Build builds the program for GOOS target and GOARCH wasm without running it, and returns the module
+	// if it succeeded. The output of the build is written to w. An error is only returned if it could not be built.
+	Build(ctx context.Context, ws *Workspace, target string, w io.Writer) ([]byte, *protocol.Result, error)
+	// Run starts building and running the program, ctx only bounds the start.
+	Run(ctx context.Context, ws *Workspace, opts Options) (Process, error)
+}
+
+// Options of a run.
+type Options struct {
+	Profile       string // seccomp profile of the program
+	Deterministic bool   // fake clock and a fixed seed of math/rand, the output is framed with the virtual time
+	Term          string // TERM of the program, if any
+	Pprof         bool   // profile the CPU and heap of the program, see CPUProfile
+	Trace         bool   // trace the execution of the program
+}
+
+// Process is a started run.
+type Process interface {
+	// Stream returns the output of the program, both have to be read until they end before Wait is called.
+	Stream() (stdout, stderr io.Reader)
+	// Stop asks the run to end, it still reports how it ended.
+	Stop()
+	// Wait returns how the run ended, an error is only returned if that is not known.
+	Wait() (*protocol.Result, error)
+	// Artifacts returns the files of the run besides its output by name, after Wait.
+	Artifacts() map[string]Artifact
+}
+
+// Workspace is the module directory of a run.
+type Workspace struct {
+	Dir     string
+	Version string
+	Env     []string // of the go command building in it
+	code    string   // for an executor that builds elsewhere
+	release func()
+}
+
+// Release discards the workspace.
+func (w *Workspace) Release() {
+	if w.release != nil {
+		w.release()
+	}
+}
+
+// process is a Process of functions.
+type process struct {
+	stdout, stderr io.Reader
+	stop           func()
+	wait           func() (*protocol.Result, error)
+	artifacts      func() map[string]Artifact // none if nil
+}
+
+func (p *process) Stream() (io.Reader, io.Reader) {
+	return p.stdout, p.stderr
+}
+
+func (p *process) Stop() {
+	p.stop()
+}
+
+func (p *process) Wait() (*protocol.Result, error) {
+	return p.wait()
+}
+
+func (p *process) Artifacts() map[string]Artifact {
+	if p.artifacts == nil {
+		return nil
+	}
+	return p.artifacts()
+}
+
+// goProcess runs f in a goroutine with the writing ends of the output, which are closed when it returns.
+// The process is stopped by canceling the context of f.
+func goProcess(f func(ctx context.Context, stdout, stderr io.Writer) (*protocol.Result, error)) Process {
+	var (
+		stdout, stdoutWriter = io.Pipe()
+		stderr, stderrWriter = io.Pipe()
+		ctx, cancel          = context.WithCancel(context.Background())
+		done                 = make(chan struct{})
+		res                  *protocol.Result
+		err                  error
+	)
+	go func() {
+		defer close(done)
+		defer stdoutWriter.Close()
+		defer stderrWriter.Close()
+		res, err = f(ctx, stdoutWriter, stderrWriter)
+	}()
+
+	return &process{
+		stdout: stdout,
+		stderr: stderr,
+		stop:   cancel,
+		wait: func() (*protocol.Result, error) {
+			<-done
+			cancel()
+			return res, err
+		},
+	}
+}
@@ -0,0 +1,62 @@
+package executor
+
+import (
+	"context"
+	"io"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/protocol"
+)
+
+// Fake builds and runs nothing. A run writes Stdout and Stderr and ends with Result, a build writes Stderr
+// and returns Module, so the handlers and the runners can be tested without a Go toolchain.
+type Fake struct {
+	Stdout, Stderr string
+	Result         *protocol.Result // a successful run if nil
+	Module         []byte
+	Artifacts      map[string]Artifact
+	Err            error           // returned by Run if set
+	Hold           <-chan struct{} // a run writes nothing until it is closed, or ends killed when stopped
+}
+
+func (f *Fake) Prepare(_ context.Context, version, _ string) (*Workspace, error) {
+	return &Workspace{Version: version}, nil
+}
+
+func (f *Fake) Build(_ context.Context, _ *Workspace, _ string, w io.Writer) ([]byte, *protocol.Result, error) {
+	if _, err := io.WriteString(w, f.Stderr); err != nil {
+		return nil, nil, err
+	}
+	if f.Result != nil {
+		res := *f.Result
+		return nil, &res, nil
+	}
+	return f.Module, &protocol.Result{Stage: protocol.StageBuild}, nil
+}
+
+func (f *Fake) Run(_ context.Context, _ *Workspace, _ Options) (Process, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	p := goProcess(func(ctx context.Context, stdout, stderr io.Writer) (*protocol.Result, error) {
+		if f.Hold != nil {
+			select {
+			case <-f.Hold:
+			case <-ctx.Done():
+				return &protocol.Result{Stage: protocol.StageRun, ExitCode: -1, Signal: "killed"}, nil
+			}
+		}
+		if _, err := io.WriteString(stdout, f.Stdout); err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(stderr, f.Stderr); err != nil {
+			return nil, err
+		}
+		res := protocol.Result{Stage: protocol.StageRun}
+		if f.Result != nil {
+			res = *f.Result
+		}
+		return &res, nil
+	}).(*process)
+	p.artifacts = func() map[string]Artifact { return f.Artifacts }
+	return p, nil
+}
@@ -0,0 +1,221 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/protocol"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/toolchain"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/workspace"
+)
+
+const (
+	codeFileName   = "main.go"
+	moduleFileName = "main.wasm"
+	artifactsDir   = "artifacts" // in the workspace
+)
+
+// Local runs the programs with the sandbox runner, which isolates them with seccomp, namespaces and a cgroup.
+type Local struct {
+	runner     string
+	tempDir    string // of the runner, its default if empty
+	workspaces *workspace.Manager
+	env        func() []string // added to the environment of the runner
+}
+
+// NewLocal returns an executor of the sandbox runner at the path, env is called for every run.
+func NewLocal(runner, tempDir string, workspaces *workspace.Manager, env func() []string) *Local {
+	// the runner builds in the workspaces, not in the working directory of the server
+	if abs, err := filepath.Abs(runner); err == nil {
+		runner = abs
+	}
+	return &Local{runner: runner, tempDir: tempDir, workspaces: workspaces, env: env}
+}
+
+func (l *Local) Prepare(ctx context.Context, version, code string) (*Workspace, error) {
+	goRoot, err := toolchain.GoRoot(version)
+	if err != nil {
+		return nil, err
+	}
+	// take a pre-initialized module directory
+	ws, err := l.workspaces.Get(ctx, goRoot)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get a workspace: %v", err)
+	}
+	if err = os.WriteFile(filepath.Join(ws.Dir, codeFileName), []byte(code), 0644); err != nil {
+		ws.Release()
+		return nil, fmt.Errorf("Failed to write code file: %v", err)
+	}
+	return &Workspace{Dir: ws.Dir, Version: version, Env: ws.Env(), release: ws.Release}, nil
+}
+
+func (l *Local) Build(ctx context.Context, ws *Workspace, target string, w io.Writer) ([]byte, *protocol.Result, error) {
+	output := filepath.Join(ws.Dir, moduleFileName)
+	cmd := exec.CommandContext(ctx, l.runner, l.args("-target", target, "-o", output, ws.Dir)...)
+	cmd.Env = l.runnerEnv(ws)
+	// the runner stops the build and reports
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.Stdout = w
+	cmd.Stderr = w
+	results, resultWriter, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to get result pipe: %v", err)
+	}
+	defer results.Close()
+	cmd.ExtraFiles = []*os.File{resultWriter}
+
+	err = cmd.Start()
+	resultWriter.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to start command: %v", err)
+	}
+	res, e := protocol.Read(results)
+	err = cmd.Wait()
+	if e != nil {
+		if err == nil {
+			err = e
+		}
+		return nil, nil, err
+	}
+	if !res.Compiled() {
+		return nil, res, nil
+	}
+
+	module, err := os.ReadFile(output)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read the module: %v", err)
+	}
+	return module, res, nil
+}
+
+func (l *Local) Run(_ context.Context, ws *Workspace, opts Options) (Process, error) {
+	args := []string{"-profile", opts.Profile, "-audit"}
+	if opts.Deterministic {
+		args = append(args, "-faketime")
+	}
+	if opts.Term != "" {
+		args = append(args, "-term", opts.Term)
+	}
+	var artifacts []string
+	if opts.Pprof {
+		args = append(args, "-pprof")
+		artifacts = append(artifacts, CPUProfile, HeapProfile)
+	}
+	if opts.Trace {
+		args = append(args, "-trace")
+		artifacts = append(artifacts, Trace)
+	}
+	dir := filepath.Join(ws.Dir, artifactsDir)
+	if artifacts != nil {
+		args = append(args, "-artifacts", dir)
+	}
+	cmd := exec.Command(l.runner, l.args(append(args, ws.Dir)...)...)
+	cmd.Env = l.runnerEnv(ws)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get stdout pipe: %v", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get stderr pipe: %v", err)
+	}
+	results, resultWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get result pipe: %v", err)
+	}
+	// the first extra file is protocol.ResultFd
+	cmd.ExtraFiles = []*os.File{resultWriter}
+
+	err = cmd.Start()
+	resultWriter.Close()
+	if err != nil {
+		results.Close()
+		return nil, fmt.Errorf("Failed to start command: %v", err)
+	}
+
+	return &process{
+		stdout: stdout,
+		stderr: stderr,
+		// the runner ends the program and reports
+		stop: func() {
+			if err := cmd.Process.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+				log.Printf("failed to stop process: %s", err)
+			}
+		},
+		wait: func() (*protocol.Result, error) {
+			defer results.Close()
+			err := cmd.Wait()
+			res, e := protocol.Read(results)
+			if e != nil {
+				if err == nil {
+					err = e
+				}
+				return nil, err
+			}
+			return res, nil
+		},
+		artifacts: func() map[string]Artifact {
+			return readArtifacts(dir, artifacts...)
+		},
+	}, nil
+}
+
+// readArtifacts reads the files of the names in dir, the ones that are missing or empty are left out.
+func readArtifacts(dir string, names ...string) map[string]Artifact {
+	artifacts := map[string]Artifact{}
+	for _, name := range names {
+		if a, err := readArtifact(filepath.Join(dir, name)); err == nil && a.Size > 0 {
+			artifacts[name] = a
+		}
+	}
+	return artifacts
+}
+
+// readArtifact reads the file unless it is too large, which is known before reading it.
+func readArtifact(path string) (Artifact, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Artifact{}, err
+	}
+	a := Artifact{Size: info.Size()}
+	if a.Size == 0 || a.TooLarge() {
+		return a, nil
+	}
+	a.Data, err = io.ReadAll(io.LimitReader(f, config.ArtifactMaxSize))
+	return a, err
+}
+
+// args returns the arguments of the runner, the result is written to protocol.ResultFd.
+func (l *Local) args(args ...string) []string {
+	common := []string{"-result-fd", strconv.Itoa(protocol.ResultFd)}
+	if l.tempDir != "" {
+		common = append(common, "-temp-dir", l.tempDir)
+	}
+	return append(common, args...)
+}
+
+// runnerEnv returns the environment of the runner building in the workspace.
+func (l *Local) runnerEnv(ws *Workspace) []string {
+	env := append(os.Environ(), ws.Env...)
+	if l.env != nil {
+		env = append(env, l.env()...)
+	}
+	return env
+}
@@ -0,0 +1,344 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/protocol"
+	"golang.org/x/net/http2"
+)
+
+const (
+	healthTimeout = 2 * time.Second
+	stopTimeout   = 5 * time.Second
+)
+
+// Pool is the runners of the API server. A build or run goes to the healthy runner with the fewest in progress,
+// and to another one if the runner cannot take it.
+type Pool struct {
+	client  *http.Client
+	token   string // of the runners, see Server
+	runners []*runner
+	next    atomic.Uint64 // breaks ties between the runners, round-robin
+}
+
+type runner struct {
+	addr    string // host:port
+	healthy atomic.Bool
+	runs    atomic.Int64 // sent by this API server and not ended
+}
+
+// NewPool returns a pool of the runners at the addresses, host:port, checked every interval.
+// The requests have the token of the runners.
+func NewPool(addrs []string, token string, interval time.Duration) *Pool {
+	p := newPool(addrs, token)
+	go p.check(interval)
+	return p
+}
+
+func newPool(addrs []string, token string) *Pool {
+	p := &Pool{
+		token: token,
+		client: &http.Client{Transport: &http2.Transport{
+			// HTTP/2 without TLS, see Server.Handler
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+			// a runner that has gone is noticed during a long run
+			ReadIdleTimeout: 10 * time.Second,
+			PingTimeout:     5 * time.Second,
+		}},
+	}
+	for _, addr := range addrs {
+		r := &runner{addr: addr}
+		r.healthy.Store(true) // until checked
+		p.runners = append(p.runners, r)
+	}
+	return p
+}
+
+// check checks the health of every runner each interval.
+func (p *Pool) check(interval time.Duration) {
+	for {
+		p.checkAll()
+		time.Sleep(interval)
+	}
+}
+
+// checkAll checks the health of every runner at once and returns when all are checked.
+func (p *Pool) checkAll() {
+	var wg sync.WaitGroup
+	for _, r := range p.runners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+			defer cancel()
+			var h health
+			p.setHealthy(r, p.call(ctx, r, http.MethodGet, healthPath, nil, &h) == nil)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) setHealthy(r *runner, healthy bool) {
+	if r.healthy.Swap(healthy) != healthy {
+		if healthy {
+			log.Printf("runner %s is healthy", r.addr)
+		} else {
+			log.Printf("runner %s is unhealthy", r.addr)
+		}
+	}
+}
+
+// pick returns the runner to try next that is not tried yet, a healthy one with the fewest runs if any, nil if none is left.
+func (p *Pool) pick(tried map[*runner]bool) *runner {
+	var best *runner
+	start := p.next.Add(1)
+	for i := range p.runners {
+		r := p.runners[(start+uint64(i))%uint64(len(p.runners))]
+		if tried[r] {
+			continue
+		}
+		switch {
+		case best == nil,
+			r.healthy.Load() && !best.healthy.Load(),
+			r.healthy.Load() == best.healthy.Load() && r.runs.Load() < best.runs.Load():
+			best = r
+		}
+	}
+	return best
+}
+
+// do sends the request to the runners in turn until one takes it, f is called with the response of that one.
+// A runner that cannot be reached, refuses the token, is busy or fails is retried on the next one.
+func (p *Pool) do(ctx context.Context, path string, in any, f func(r *runner, res *http.Response) error) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	tried := map[*runner]bool{}
+	errs := []string{}
+	for r := p.pick(tried); r != nil; r = p.pick(tried) {
+		tried[r] = true
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+r.addr+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+p.token)
+		res, err := p.client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			p.setHealthy(r, false)
+			errs = append(errs, err.Error())
+			continue
+		}
+		if res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusUnauthorized {
+			// a runner without the token of the pool is not one of its runners
+			if res.StatusCode == http.StatusUnauthorized {
+				p.setHealthy(r, false)
+			}
+			errs = append(errs, runnerError(r, res).Error())
+			res.Body.Close()
+			continue
+		}
+		p.setHealthy(r, true)
+		return f(r, res)
+	}
+	return fmt.Errorf("no runner available: %s", strings.Join(errs, "; "))
+}
+
+// call calls the API of the runner with in as the JSON body if not nil, the response is decoded into out.
+func (p *Pool) call(ctx context.Context, r *runner, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+r.addr+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err = runnerError(r, res); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func runnerError(r *runner, res *http.Response) error {
+	if res.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+	var msg errorResponse
+	if err := json.NewDecoder(res.Body).Decode(&msg); err != nil || msg.Error == "" {
+		return fmt.Errorf("runner %s: %s", r.addr, res.Status)
+	}
+	if res.StatusCode == http.StatusBadRequest && msg.Error == ErrNotSupported.Error() {
+		return ErrNotSupported
+	}
+	return fmt.Errorf("runner %s: %s", r.addr, msg.Error)
+}
+
+// Remote builds and runs the programs on the runners of a pool, with the executor of the backend there.
+type Remote struct {
+	pool    *Pool
+	backend string
+}
+
+func NewRemote(pool *Pool, backend string) *Remote {
+	return &Remote{pool: pool, backend: backend}
+}
+
+// Prepare keeps the code for the runner, which prepares the workspace.
+func (x *Remote) Prepare(_ context.Context, version, code string) (*Workspace, error) {
+	return &Workspace{Version: version, code: code}, nil
+}
+
+func (x *Remote) Build(ctx context.Context, ws *Workspace, target string, w io.Writer) ([]byte, *protocol.Result, error) {
+	var out buildResponse
+	err := x.pool.do(ctx, buildPath, buildRequest{Backend: x.backend, Version: ws.Version, Code: ws.code, Target: target},
+		func(r *runner, res *http.Response) error {
+			r.runs.Add(1)
+			defer r.runs.Add(-1)
+			defer res.Body.Close()
+			if err := runnerError(r, res); err != nil {
+				return err
+			}
+			return json.NewDecoder(res.Body).Decode(&out)
+		})
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = w.Write(out.Output); err != nil {
+		return nil, nil, err
+	}
+	return out.Module, out.Result, nil
+}
+
+func (x *Remote) Run(ctx context.Context, ws *Workspace, opts Options) (Process, error) {
+	// the request outlives ctx, which only bounds getting a runner
+	reqCtx, cancel := context.WithCancel(context.Background())
+	stopCancel := context.AfterFunc(ctx, cancel)
+	defer stopCancel()
+
+	var (
+		r      *runner
+		body   io.ReadCloser
+		events *json.Decoder
+		id     string
+	)
+	err := x.pool.do(reqCtx, runPath, runRequest{Backend: x.backend, Version: ws.Version, Code: ws.code, Options: opts},
+		func(rr *runner, res *http.Response) error {
+			if err := runnerError(rr, res); err != nil {
+				res.Body.Close()
+				return err
+			}
+			dec := json.NewDecoder(res.Body)
+			var first runEvent
+			if err := dec.Decode(&first); err != nil {
+				res.Body.Close()
+				return fmt.Errorf("runner %s: %w", rr.addr, err)
+			}
+			r, body, events, id = rr, res.Body, dec, first.ID
+			return nil
+		})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r.runs.Add(1)
+
+	var (
+		stdout, stdoutWriter = io.Pipe()
+		stderr, stderrWriter = io.Pipe()
+		done                 = make(chan struct{})
+		res                  *protocol.Result
+		artifacts            map[string]Artifact
+	)
+	go func() {
+		defer close(done)
+		defer r.runs.Add(-1)
+		defer body.Close()
+		defer stdoutWriter.Close()
+		defer stderrWriter.Close()
+		for {
+			var e runEvent
+			if err = events.Decode(&e); err != nil {
+				err = fmt.Errorf("runner %s: the run was lost: %w", r.addr, err)
+				return
+			}
+			switch {
+			case e.Stdout != nil:
+				if _, err = stdoutWriter.Write(e.Stdout); err != nil {
+					return
+				}
+			case e.Stderr != nil:
+				if _, err = stderrWriter.Write(e.Stderr); err != nil {
+					return
+				}
+			case e.Artifacts != nil:
+				artifacts = e.Artifacts
+			case e.Result != nil:
+				res = e.Result
+				return
+			default:
+				err = fmt.Errorf("runner %s: %s", r.addr, e.Error)
+				return
+			}
+		}
+	}()
+
+	var stopOnce sync.Once
+	return &process{
+		stdout: stdout,
+		stderr: stderr,
+		// the runner ends the program and reports
+		stop: func() {
+			stopOnce.Do(func() {
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+					defer cancel()
+					if err := x.pool.call(ctx, r, http.MethodPost, strings.Replace(stopPath, "{id}", id, 1), nil, nil); err != nil {
+						log.Printf("failed to stop run: %s", err)
+					}
+				}()
+			})
+		},
+		wait: func() (*protocol.Result, error) {
+			<-done
+			cancel()
+			return res, err
+		},
+		artifacts: func() map[string]Artifact {
+			return artifacts
+		},
+	}, nil
+}
@@ -0,0 +1,249 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+)
+
+const testToken = "secret"
+
+func TestMain(m *testing.M) {
+	// the runners check the version of a request against the installed toolchains, the one running the tests is
+	config.GoRoots[config.DefaultGoVersion] = runtime.GOROOT()
+	os.Exit(m.Run())
+}
+
+// countingFake counts the runs that reach the executor of a runner.
+type countingFake struct {
+	*Fake
+	runs atomic.Int64
+}
+
+func (f *countingFake) Run(ctx context.Context, ws *Workspace, opts Options) (Process, error) {
+	f.runs.Add(1)
+	return f.Fake.Run(ctx, ws, opts)
+}
+
+// startRunner serves the fake as the native backend of a runner on l, a new listener if nil, and returns its address.
+func startRunner(t *testing.T, fake Executor, maxRuns int, l net.Listener) (*httptest.Server, string) {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(NewServer(map[string]Executor{"native": fake}, maxRuns, testToken).Handler())
+	if l != nil {
+		srv.Listener.Close()
+		srv.Listener = l
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv, srv.Listener.Addr().String()
+}
+
+// run runs a program on the pool and returns its stdout once it has ended.
+func run(t *testing.T, p *Pool) string {
+	t.Helper()
+	proc := start(t, p)
+	return finish(t, proc)
+}
+
+func start(t *testing.T, p *Pool) Process {
+	t.Helper()
+	proc, err := NewRemote(p, "native").Run(context.Background(), &Workspace{code: "package main"}, Options{Profile: config.DefaultProfile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proc
+}
+
+func finish(t *testing.T, proc Process) string {
+	t.Helper()
+	stdout, stderr := proc.Stream()
+	go io.Copy(io.Discard, stderr)
+	out, err := io.ReadAll(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := proc.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.OK() {
+		t.Fatalf("result is %+v", res)
+	}
+	return string(out)
+}
+
+func TestPoolSpreadsRuns(t *testing.T) {
+	hold := make(chan struct{})
+	var (
+		a    = &countingFake{Fake: &Fake{Stdout: "a", Hold: hold}}
+		b    = &countingFake{Fake: &Fake{Stdout: "b", Hold: hold}}
+		_, x = startRunner(t, a, 10, nil)
+		_, y = startRunner(t, b, 10, nil)
+		p    = newPool([]string{x, y}, testToken)
+	)
+
+	// the runs are held, the runner with the fewer in progress takes the next one
+	var procs []Process
+	for range 4 {
+		procs = append(procs, start(t, p))
+	}
+	if a.runs.Load() != 2 || b.runs.Load() != 2 {
+		t.Errorf("the runners took %d and %d of the runs, want 2 each", a.runs.Load(), b.runs.Load())
+	}
+
+	close(hold)
+	var outputs []string
+	for _, proc := range procs {
+		outputs = append(outputs, finish(t, proc))
+	}
+	if got := strings.Join(outputs, ""); strings.Count(got, "a") != 2 || strings.Count(got, "b") != 2 {
+		t.Errorf("the outputs are %q", outputs)
+	}
+}
+
+func TestPoolRetriesBusyRunner(t *testing.T) {
+	var (
+		hold    = make(chan struct{})
+		busy    = &countingFake{Fake: &Fake{Stdout: "busy", Hold: hold}}
+		free    = &countingFake{Fake: &Fake{Stdout: "free"}}
+		_, addr = startRunner(t, busy, 1, nil)
+		_, ok   = startRunner(t, free, 1, nil)
+	)
+	defer close(hold)
+
+	// another API server takes the only slot of the busy runner, this pool does not know
+	start(t, newPool([]string{addr}, testToken))
+
+	p := newPool([]string{ok, addr}, testToken)
+	// round-robin, each of them is tried first once
+	for range 2 {
+		if out := run(t, p); out != "free" {
+			t.Errorf("stdout is %q", out)
+		}
+	}
+	if busy.runs.Load() != 1 || free.runs.Load() != 2 {
+		t.Errorf("the busy runner took %d runs and the free one %d, want 1 and 2", busy.runs.Load(), free.runs.Load())
+	}
+	// a busy runner stays healthy
+	for _, r := range p.runners {
+		if !r.healthy.Load() {
+			t.Errorf("runner %s is unhealthy", r.addr)
+		}
+	}
+}
+
+func TestPoolRetriesDeadRunner(t *testing.T) {
+	var (
+		free       = &countingFake{Fake: &Fake{Stdout: "free"}}
+		dead, addr = startRunner(t, &Fake{}, 10, nil)
+		_, ok      = startRunner(t, free, 10, nil)
+		p          = newPool([]string{ok, addr}, testToken)
+	)
+	// it dies before it is checked, a runner is healthy until then
+	dead.Close()
+
+	for range 2 {
+		if out := run(t, p); out != "free" {
+			t.Errorf("stdout is %q", out)
+		}
+	}
+	if p.runners[1].healthy.Load() {
+		t.Error("the dead runner is still healthy")
+	}
+}
+
+func TestPoolSkipsUnhealthyRunner(t *testing.T) {
+	// the runner is down when it is checked, and back afterward
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var (
+		back  = &countingFake{Fake: &Fake{Stdout: "back"}}
+		free  = &countingFake{Fake: &Fake{Stdout: "free"}}
+		_, ok = startRunner(t, free, 10, nil)
+		p     = newPool([]string{addr, ok}, testToken)
+	)
+	p.checkAll()
+	if p.runners[0].healthy.Load() {
+		t.Fatal("the runner that is down is healthy")
+	}
+	if l, err = net.Listen("tcp", addr); err != nil {
+		t.Skipf("the address cannot be listened on again: %s", err)
+	}
+	startRunner(t, back, 10, l)
+
+	for range 3 {
+		if out := run(t, p); out != "free" {
+			t.Errorf("stdout is %q", out)
+		}
+	}
+	if back.runs.Load() != 0 {
+		t.Errorf("the unhealthy runner took %d runs", back.runs.Load())
+	}
+
+	// the next check finds it healthy again
+	p.checkAll()
+	var outputs []string
+	for range 2 {
+		outputs = append(outputs, run(t, p))
+	}
+	if back.runs.Load() != 1 {
+		t.Errorf("the runner that is back took %d of the runs %q, want 1", back.runs.Load(), outputs)
+	}
+}
+
+func TestRunnerToken(t *testing.T) {
+	fake := &countingFake{Fake: &Fake{}}
+	srv, addr := startRunner(t, fake, 10, nil)
+
+	for _, tc := range []struct {
+		header string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer " + testToken, http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+healthPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Errorf("Authorization %q: status %s, want %d", tc.header, res.Status, tc.status)
+		}
+	}
+
+	// a pool with another token is refused, and the runner is not one of its runners
+	p := newPool([]string{addr}, "wrong")
+	_, err := NewRemote(p, "native").Run(context.Background(), &Workspace{}, Options{Profile: config.DefaultProfile})
+	if err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("the run with another token: %v, want unauthorized", err)
+	}
+	if p.runners[0].healthy.Load() {
+		t.Error("the runner that refused the token is healthy")
+	}
+	if fake.runs.Load() != 0 {
+		t.Errorf("the runner took %d runs with another token", fake.runs.Load())
+	}
+}
@@ -0,0 +1,310 @@
+package executor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/protocol"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/toolchain"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// the API of a runner, served by Server and called by Remote
+const (
+	healthPath = "/health"
+	runPath    = "/run"
+	buildPath  = "/build"
+	stopPath   = "/runs/{id}/stop"
+)
+
+type runRequest struct {
+	Backend string  `json:"backend"`
+	Version string  `json:"version"`
+	Code    string  `json:"code"`
+	Options Options `json:"options"`
+}
+
+type buildRequest struct {
+	Backend string `json:"backend"`
+	Version string `json:"version"`
+	Code    string `json:"code"`
+	Target  string `json:"target"`
+}
+
+// the runner checks the requests like the API server does, it does not rely on them being checked
+type request interface {
+	backend() string
+	check() error
+}
+
+func (r *runRequest) backend() string { return r.Backend }
+
+func (r *runRequest) check() error {
+	if _, err := toolchain.GoRoot(r.Version); err != nil {
+		return err
+	}
+	if !config.SandboxProfiles[r.Options.Profile] {
+		return fmt.Errorf("unknown profile: %s", r.Options.Profile)
+	}
+	return nil
+}
+
+func (r *buildRequest) backend() string { return r.Backend }
+
+func (r *buildRequest) check() error {
+	if _, err := toolchain.GoRoot(r.Version); err != nil {
+		return err
+	}
+	if !config.WasmTargets[r.Target] {
+		return fmt.Errorf("unknown target: %s", r.Target)
+	}
+	return nil
+}
+
+type buildResponse struct {
+	Output []byte           `json:"output"`
+	Result *protocol.Result `json:"result"`
+	Module []byte           `json:"module,omitempty"`
+}
+
+type health struct {
+	Runs    int `json:"runs"`     // builds and runs in progress
+	MaxRuns int `json:"max_runs"` // more are refused
+}
+
+// runEvent is a line of the response of a run: the ID of the run first, then the output as it is written,
+// and lastly the artifacts, if any, and the result or an error.
+type runEvent struct {
+	ID        string              `json:"id,omitempty"`
+	Stdout    []byte              `json:"stdout,omitempty"`
+	Stderr    []byte              `json:"stderr,omitempty"`
+	Artifacts map[string]Artifact `json:"artifacts,omitempty"`
+	Result    *protocol.Result    `json:"result,omitempty"`
+	Error     string              `json:"error,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server runs the builds and runs of the API servers with the executors of the runner, by the name of their backend.
+type Server struct {
+	executors map[string]Executor
+	maxRuns   int64
+	token     string // shared with the API servers, see Pool
+	runs      atomic.Int64
+
+	lock      sync.Mutex
+	processes map[string]Process // by the ID of the run
+}
+
+// NewServer returns a server of the executors that refuses builds and runs over maxRuns at once,
+// and the requests without the token as a bearer token.
+func NewServer(executors map[string]Executor, maxRuns int, token string) *Server {
+	return &Server{executors: executors, maxRuns: int64(maxRuns), token: token, processes: map[string]Process{}}
+}
+
+// Handler returns the handler of the API, over HTTP/2 without TLS so that the output of a run is streamed.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+healthPath, s.health)
+	mux.HandleFunc("POST "+runPath, s.run)
+	mux.HandleFunc("POST "+buildPath, s.build)
+	mux.HandleFunc("POST "+stopPath, s.stop)
+	return h2c.NewHandler(s.authorize(mux), &http2.Server{})
+}
+
+// authorize refuses the requests that do not have the token of the server.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	want := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeJSON(w, http.StatusUnauthorized, errorResponse{"unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) health(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, health{Runs: int(s.runs.Load()), MaxRuns: int(s.maxRuns)})
+}
+
+func (s *Server) run(w http.ResponseWriter, r *http.Request) {
+	var req runRequest
+	ex, ok := s.begin(w, r, &req)
+	if !ok {
+		return
+	}
+	defer s.runs.Add(-1)
+
+	ws, err := ex.Prepare(r.Context(), req.Version, req.Code)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	defer ws.Release()
+	p, err := ex.Run(r.Context(), ws, req.Options)
+	if errors.Is(err, ErrNotSupported) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+
+	id := newRunID()
+	s.lock.Lock()
+	s.processes[id] = p
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.processes, id)
+		s.lock.Unlock()
+	}()
+	// the API server has gone, nobody waits for the result
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			p.Stop()
+		case <-stopped:
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	var (
+		lock sync.Mutex // protects w
+		enc  = json.NewEncoder(w)
+		wg   sync.WaitGroup
+	)
+	send := func(e runEvent) {
+		lock.Lock()
+		defer lock.Unlock()
+		if err := enc.Encode(e); err != nil {
+			return // the API server has gone, the process is stopped
+		}
+		http.NewResponseController(w).Flush()
+	}
+	send(runEvent{ID: id})
+
+	stdout, stderr := p.Stream()
+	forward := func(r io.Reader, event func([]byte) runEvent) {
+		defer wg.Done()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				send(event(buf[:n]))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go forward(stdout, func(b []byte) runEvent { return runEvent{Stdout: b} })
+	go forward(stderr, func(b []byte) runEvent { return runEvent{Stderr: b} })
+	wg.Wait()
+
+	res, err := p.Wait()
+	if err != nil {
+		log.Printf("failed to read the result: %s", err)
+		send(runEvent{Error: err.Error()})
+		return
+	}
+	if artifacts := p.Artifacts(); len(artifacts) > 0 {
+		send(runEvent{Artifacts: artifacts})
+	}
+	send(runEvent{Result: res})
+}
+
+func (s *Server) build(w http.ResponseWriter, r *http.Request) {
+	var req buildRequest
+	ex, ok := s.begin(w, r, &req)
+	if !ok {
+		return
+	}
+	defer s.runs.Add(-1)
+
+	ws, err := ex.Prepare(r.Context(), req.Version, req.Code)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	defer ws.Release()
+	var res buildResponse
+	var out bytes.Buffer
+	res.Module, res.Result, err = ex.Build(r.Context(), ws, req.Target, &out)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	res.Output = out.Bytes()
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) stop(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	p, ok := s.processes[r.PathValue("id")]
+	s.lock.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{"run not found"})
+		return
+	}
+	p.Stop()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// begin decodes and checks the request into req and takes a slot of a run, the response is written if it is not ok.
+func (s *Server) begin(w http.ResponseWriter, r *http.Request, req request) (Executor, bool) {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return nil, false
+	}
+	ex := s.executors[req.backend()]
+	if ex == nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"unknown backend: " + req.backend()})
+		return nil, false
+	}
+	if err := req.check(); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return nil, false
+	}
+	if s.runs.Add(1) > s.maxRuns {
+		s.runs.Add(-1)
+		// the API server tries another runner
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{"the runner is busy"})
+		return nil, false
+	}
+	return ex, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %s", err)
+	}
+}
+
+func newRunID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
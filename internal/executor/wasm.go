@@ -0,0 +1,47 @@
+package executor
+
+import (
+	"context"
+	"io"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/protocol"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/wasm"
+)
+
+// Wasm builds the programs for wasip1 with another executor and runs the modules in the server,
+// it needs neither seccomp nor namespaces. The build errors are written to stderr.
+// The fake clock of the runtime does not work on wasip1, so it has no deterministic runs. Nor does it profile or trace.
+type Wasm struct {
+	builder Executor
+	limits  wasm.Limits
+}
+
+func NewWasm(builder Executor, limits wasm.Limits) *Wasm {
+	return &Wasm{builder: builder, limits: limits}
+}
+
+func (x *Wasm) Prepare(ctx context.Context, version, code string) (*Workspace, error) {
+	return x.builder.Prepare(ctx, version, code)
+}
+
+func (x *Wasm) Build(ctx context.Context, ws *Workspace, target string, w io.Writer) ([]byte, *protocol.Result, error) {
+	return x.builder.Build(ctx, ws, target, w)
+}
+
+func (x *Wasm) Run(_ context.Context, ws *Workspace, opts Options) (Process, error) {
+	if opts.Deterministic || opts.Pprof || opts.Trace {
+		return nil, ErrNotSupported
+	}
+	var env []string
+	if opts.Term != "" {
+		env = append(env, "TERM="+opts.Term)
+	}
+
+	return goProcess(func(ctx context.Context, stdout, stderr io.Writer) (*protocol.Result, error) {
+		module, res, err := x.builder.Build(ctx, ws, "wasip1", stderr)
+		if err != nil || module == nil {
+			return res, err
+		}
+		return wasm.Run(ctx, module, env, stdout, stderr, x.limits), nil
+	}), nil
+}
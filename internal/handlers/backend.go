@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/executor"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/modproxy"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/wasm"
+)
+
+// the backends a program can run on, see request.backend
+const (
+	nativeBackendName = "native" // a process of the sandbox runner
+	wasmBackendName   = "wasm"   // built for wasip1 and run in the server by an embedded runtime
+	dockerBackendName = "docker" // a container per run of the local Docker daemon
+)
+
+var (
+	local = executor.NewLocal(sandboxRunner, config.String(config.SandboxTempDirKey, ""), workspaces, proxyEnv)
+
+	localBackends = map[string]executor.Executor{
+		nativeBackendName: local,
+		wasmBackendName: executor.NewWasm(local, wasm.Limits{
+			Memory: uint64(config.Int(config.WasmMemoryLimitKey, config.WasmMemoryLimit)),
+			Calls:  uint64(config.PositiveInt(config.WasmMaxCallsKey, config.WasmMaxCalls)),
+			Time:   config.SandboxCPUTimeLimit * time.Second,
+		}),
+		dockerBackendName: executor.NewDocker(
+			config.String(config.DockerSocketKey, config.DockerSocket),
+			config.String(config.DockerImageKey, config.DockerImage),
+			config.String(config.DockerWorkDirKey, config.DockerWorkDir),
+		),
+	}
+)
+
+// backends run the programs on the runners if there are any, see Runner, and in the server otherwise.
+var backends = func() map[string]executor.Executor {
+	addrs := config.List(config.RunnersKey)
+	if len(addrs) == 0 {
+		return localBackends
+	}
+	token := config.String(config.RunnerTokenKey, "")
+	if token == "" {
+		log.Fatalf("%s is needed with %s", config.RunnerTokenKey, config.RunnersKey)
+	}
+	pool := executor.NewPool(addrs, token, config.RunnerHealthInterval*time.Second)
+	remote := make(map[string]executor.Executor, len(localBackends))
+	for name := range localBackends {
+		remote[name] = executor.NewRemote(pool, name)
+	}
+	return remote
+}()
+
+// Runner serves the builds and runs of the API servers with the backends of this server,
+// to the ones with its token, RUNNER_TOKEN. It fails without one.
+func Runner() (http.Handler, error) {
+	token := config.String(config.RunnerTokenKey, "")
+	if token == "" {
+		return nil, fmt.Errorf("%s is not set", config.RunnerTokenKey)
+	}
+	return executor.NewServer(localBackends, config.Int(config.ExecuteMaxConcurrentKey, config.ExecuteMaxConcurrent), token).Handler(), nil
+}
+
+// proxyEnv is added to the environment of the sandbox runner, third-party modules only come from the local proxy.
+func proxyEnv() []string {
+	return []string{
+		"GOPROXY=http://" + config.String(config.ModProxyAddrKey, config.ModProxyAddr),
+		"GONOSUMDB=" + modproxy.Default().NoSumDB(),
+	}
+}
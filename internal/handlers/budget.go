@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+)
+
+const truncatedEvent = "truncated"
+
+// the output limits of a run
+var (
+	maxOutputBytes = config.PositiveInt(config.ExecuteMaxOutputBytesKey, config.ExecuteMaxOutputBytes)
+	maxOutputLines = config.PositiveInt(config.ExecuteMaxOutputLinesKey, config.ExecuteMaxOutputLines)
+)
+
+// budget limits the combined output of the streams of a run by bytes and lines.
+// Once it is exceeded, exceed is called and nothing more is let through, but the output is still counted.
+type budget struct {
+	lock               sync.Mutex
+	bytes, lines       int
+	maxBytes, maxLines int
+	pending            map[string]bool // the stream has a line that is not ended yet and not empty
+	exceeded           bool
+	exceed             func()
+}
+
+func newBudget(exceed func()) *budget {
+	return &budget{maxBytes: maxOutputBytes, maxLines: maxOutputLines, pending: map[string]bool{}, exceed: exceed}
+}
+
+// take counts the output of the stream and returns the part of it that is within the budget.
+// The lines are counted like the splitter sends them, ended by any of lineEnds and not empty.
+func (b *budget) take(stream string, data []byte) []byte {
+	b.lock.Lock()
+	within, exceeded := data, false
+	for i, c := range data {
+		if !b.exceeded && (b.bytes == b.maxBytes || b.lines == b.maxLines) {
+			within, b.exceeded, exceeded = data[:i], true, true
+		}
+		b.bytes++
+		if strings.IndexByte(lineEnds, c) < 0 {
+			b.pending[stream] = true
+		} else if b.pending[stream] {
+			b.lines++
+			b.pending[stream] = false
+		}
+	}
+	if b.exceeded && !exceeded {
+		within = nil
+	}
+	b.lock.Unlock()
+
+	if exceeded {
+		b.exceed()
+	}
+	return within
+}
+
+// over reports whether the budget has been exceeded.
+func (b *budget) over() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.exceeded
+}
+
+// totals is the data of the truncated event.
+func (b *budget) totals() []byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	data, _ := json.Marshal(map[string]int{
+		"bytes":     b.bytes,
+		"lines":     b.lines,
+		"max_bytes": b.maxBytes,
+		"max_lines": b.maxLines,
+	})
+	return data
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/collab"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/db"
+)
+
+// sinkFunc adapts a function to a sink
+type sinkFunc func(event string, data []byte)
+
+func (f sinkFunc) send(event string, data []byte) {
+	f(event, data)
+}
+
+// snippetStore persists the collaborative documents as snippets
+type snippetStore struct{}
+
+func (snippetStore) Load(ctx context.Context, key string) (string, error) {
+	snippet, err := db.S3().GetObject(ctx, key)
+	return string(snippet), err
+}
+
+func (snippetStore) Save(ctx context.Context, code string) (string, error) {
+	return saveSnippet(ctx, code)
+}
+
+var hub = collab.NewHub(func(ctx context.Context, session, code, version string, emit func(event string, data []byte)) {
+	s := sinkFunc(emit)
+
+	// a session queues like a single client
+	release, err := acquire(ctx, "collab:"+session, s)
+	if err != nil {
+		emit("error", []byte(err.Error()))
+		return
+	}
+	defer release()
+
+	if err = run(ctx, request{Code: code, Version: version}, s); err != nil {
+		emit("error", []byte(err.Error()))
+	}
+}, snippetStore{})
+
+// CollabHandler joins a websocket to the collaborative editing session of the id param.
+// The optional name query is shown to the other clients, and snippet seeds a new session.
+func CollabHandler() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			log.Println("WebSocket upgrade error:", err)
+			return
+		}
+		defer ws.Close()
+
+		if err = hub.Serve(ws, c.Param("id"), c.Query("name"), c.Query("snippet")); err != nil {
+			log.Println("Collab session error:", err)
+			_ = ws.WriteJSON(response{Error: err.Error()})
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package handlers
 
+import "github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+
 const (
 	badRequestMessage = "bad request"
 	buildErrorMessage = "build failed"
@@ -8,6 +10,38 @@ const (
 type request struct {
 	Code    string `json:"code" binding:"required"`
 	Version string `json:"version"`
+	Profile string `json:"profile"` // seccomp profile, config.DefaultProfile if empty
+	Network bool   `json:"network"` // loopback-only network for the program, short for config.NetworkLoopbackProfile
+	// fake clock and a fixed seed of math/rand, the output is replayed with the delays of the program, see replay
+	Deterministic bool `json:"deterministic"`
+	// stdout is sent as output-raw events as the program wrote it, for a terminal emulator
+	Raw bool `json:"raw"`
+	// native, wasm or docker, config.ExecuteBackendKey if empty, see backends
+	Backend string `json:"backend"`
+	// CPU and heap profiles of the program, sent as a pprof event, see sendProfiles
+	Pprof bool `json:"pprof"`
+	// an execution trace of the program, sent as a trace event, see sendTrace
+	Trace bool `json:"trace"`
+}
+
+// profile returns the seccomp profile of the run.
+func (r request) profile() string {
+	switch {
+	case r.Profile != "":
+		return r.Profile
+	case r.Network:
+		return config.NetworkLoopbackProfile
+	default:
+		return config.DefaultProfile
+	}
+}
+
+// backend returns the name of the backend the program runs on.
+func (r request) backend() string {
+	if r.Backend != "" {
+		return r.Backend
+	}
+	return config.String(config.ExecuteBackendKey, config.DefaultBackend)
 }
 
 type response struct {
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/db"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/protocol"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/scheduler"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/toolchain"
+)
+
+const (
+	wasmKeyPrefix = "wasm/" // of the modules in the snippet bucket
+	wasmMIME      = "application/wasm"
+)
+
+// the ID of a module or a profile is the SHA-256 of its content
+var contentIDRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+type compileRequest struct {
+	request
+	Target string `json:"target"` // GOOS, js or wasip1
+}
+
+type compileResponse struct {
+	Target string `json:"target"`
+	Size   int    `json:"size"`
+	URL    string `json:"url"` // of the module
+	// of the wasm_exec.js of the Go version that runs a js module in the browser, empty for wasip1
+	WasmExec string `json:"wasm_exec,omitempty"`
+}
+
+// Compile builds the code for WebAssembly without running it. The module is stored with the snippets,
+// and the response has the URLs of it and of the matching wasm_exec.js.
+func Compile(c *gin.Context) {
+	var req compileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, response{
+			Error:   err.Error(),
+			Message: badRequestMessage,
+		})
+		return
+	}
+	if !checkRequest(c, req.request) {
+		return
+	}
+	if !config.WasmTargets[req.Target] {
+		c.AbortWithStatusJSON(http.StatusBadRequest, response{
+			Error:   "unknown target: " + req.Target,
+			Message: badRequestMessage,
+		})
+		return
+	}
+
+	// a build takes a slot like a run
+	release, err := acquire(c.Request.Context(), c.ClientIP(), sinkFunc(func(string, []byte) {}))
+	if err != nil {
+		if errors.Is(err, scheduler.ErrQueueFull) {
+			c.Header("Retry-After", strconv.Itoa(config.ExecuteRetryAfter))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, response{Error: err.Error()})
+		}
+		// otherwise the client has gone
+		return
+	}
+	defer release()
+
+	wasm, stderr, res, err := compile(c.Request.Context(), req.request, req.Target)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: err.Error()})
+		return
+	}
+	if !res.Compiled() {
+		msg := buildErrorMessage
+		if res.Stage == protocol.StageSetup {
+			msg = res.Error
+		}
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, response{Stderr: stderr, Error: msg})
+		return
+	}
+
+	sum := sha256.Sum256(wasm)
+	id := hex.EncodeToString(sum[:])
+	if err = db.S3().PutObject(c, wasmKeyPrefix+id, wasm); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: err.Error()})
+		return
+	}
+
+	out := compileResponse{Target: req.Target, Size: len(wasm), URL: "/wasm/" + id}
+	if req.Target == "js" {
+		out.WasmExec = "/wasm_exec.js?version=" + req.Version
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// compile builds the code with the executor of the backend, the build errors are returned as stderr.
+// An error is only returned if it could not be built.
+func compile(ctx context.Context, req request, target string) (wasm []byte, stderr string, res *protocol.Result, err error) {
+	ex := backends[req.backend()]
+	// the workspace is discarded afterwards, with the module
+	ws, err := ex.Prepare(ctx, req.Version, req.Code)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer ws.Release()
+
+	var out bytes.Buffer
+	wasm, res, err = ex.Build(ctx, ws, target, &out)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// the build errors are shown like the ones of a run
+	var lines []byte
+	errs := newOutput(stderrKey, sinkFunc(func(_ string, line []byte) {
+		lines = append(append(lines, line...), '\n')
+	}), false)
+	errs.write(out.Bytes())
+	errs.close()
+	return wasm, string(lines), res, nil
+}
+
+// FetchWasm returns the module of the id param built by Compile.
+func FetchWasm(c *gin.Context) {
+	id := c.Param("id")
+	if !contentIDRe.MatchString(id) {
+		c.AbortWithStatusJSON(http.StatusNotFound, response{Error: "module not found"})
+		return
+	}
+	wasm, err := db.S3().GetObject(c, wasmKeyPrefix+id)
+	if err != nil {
+		if errors.Is(err, db.ErrObjectNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, response{Error: "module not found"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: err.Error()})
+		return
+	}
+	// the ID is the hash of the content, it never changes
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Data(http.StatusOK, wasmMIME, wasm)
+}
+
+// WasmExec returns the wasm_exec.js of the Go version of the version query, which runs a js module.
+func WasmExec(c *gin.Context) {
+	goRoot, err := toolchain.GoRoot(c.Query("version"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, response{
+			Error:   err.Error(),
+			Message: badRequestMessage,
+		})
+		return
+	}
+	// it moved from misc/wasm to lib/wasm in Go 1.24
+	for _, dir := range []string{"lib/wasm", "misc/wasm"} {
+		path := filepath.Join(goRoot, dir, "wasm_exec.js")
+		if _, err = os.Stat(path); err == nil {
+			c.Header("Content-Type", "text/javascript; charset=utf-8")
+			c.File(path)
+			return
+		}
+	}
+	c.AbortWithStatusJSON(http.StatusNotFound, response{Error: "wasm_exec.js not found"})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/pkgdoc"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/toolchain"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	validPkg = regexp.MustCompile(`^[a-z0-9_]+(/[a-zA-Z0-9_.\-]+)*$`)
+)
+
+type docRes struct {
+	Error   string          `json:"error,omitempty"`
+	Package *pkgdoc.Package `json:"package,omitempty"`
+}
+
+// PackageDoc renders the documentation of a standard library package, optionally narrowed to one symbol.
+func PackageDoc(c *gin.Context) {
+	var (
+		pkg     = c.Query("pkg")
+		symbol  = c.Query("symbol")
+		version = c.Query("version")
+	)
+
+	if !validPkg.MatchString(pkg) || strings.Contains(pkg, "..") || strings.Contains(pkg, "internal") {
+		c.JSON(http.StatusBadRequest, docRes{Error: "invalid package"})
+		return
+	}
+
+	goRoot, err := toolchain.GoRoot(version)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, docRes{Error: err.Error()})
+		return
+	}
+
+	p, err := pkgdoc.Load(goRoot, pkg)
+	if err != nil {
+		c.JSON(http.StatusNotFound, docRes{Error: "package not found"})
+		return
+	}
+
+	if symbol != "" {
+		if p, err = p.Symbol(symbol); err != nil {
+			if errors.Is(err, pkgdoc.ErrSymbolNotFound) {
+				c.JSON(http.StatusNotFound, docRes{Error: err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, docRes{Error: err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, docRes{Package: p})
+}
@@ -1,39 +1,123 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"os/exec"
-	"path/filepath"
 	"regexp"
+	"strconv"
 	"sync"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gin-gonic/gin/render"
 	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/execution"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/executor"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/protocol"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/scheduler"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/toolchain"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/workspace"
 )
 
+var workspaces = workspace.NewManager(config.GoRoots, workspaceDir, cacheDir, config.WorkspacePoolSize)
+
+// WarmUp prepares the workspaces of every Go version, so that the first runs do not pay for it.
+func WarmUp() {
+	workspaces.WarmAll()
+}
+
 const (
-	baseDir         = "./sandboxes"
-	chunkSize       = 1
-	stdoutKey       = "stdout"
-	stderrKey       = "stderr"
-	tmpDirName      = "sandbox-"
-	sandboxRunner   = baseDir + "/go/sandbox-runner"
-	tmpFileName     = "main.go"
-	timeoutError    = "exit status 124"
+	baseDir       = "./sandboxes"
+	chunkSize     = 32 * 1024 // bytes read from the output at once
+	stdoutKey     = "stdout"
+	stderrKey     = "stderr"
+	sandboxRunner = baseDir + "/go/sandbox-runner"
+	workspaceDir  = baseDir + "/go/workspaces"
+	cacheDir      = baseDir + "/go/cache"
+	// the runner names a syscall the program was denied on a line of stderr
+	violationPrefix = "SANDBOX_VIOLATION:"
+	violationEvent  = "sandbox-violation"
+	resultEvent     = "result" // protocol.Result of the run, before the done or error event
 )
 
-func send(line []byte, event string, c *gin.Context, lock *sync.Mutex, counter *int) {
+// sink receives the events of an execution, the SSE response is one of them.
+// The data is only valid during the call.
+type sink interface {
+	send(event string, data []byte)
+}
+
+// sseSink writes the events to the client as server-sent events
+type sseSink struct {
+	c       *gin.Context
+	lock    sync.Mutex // protects c.Writer
+	started sync.Once
+}
+
+// start makes the response a stream of server-sent events, with the first event or once the run is queued.
+func (s *sseSink) start() {
+	s.started.Do(func() { setSSEHeaders(s.c) })
+}
+
+func (s *sseSink) send(event string, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.start()
+	if err := writeEvent(s.c.Writer, 0, event, data); err != nil {
+		log.Printf("failed to send event to client: %s", err)
+		return
+	}
+	s.c.Writer.Flush()
+}
+
+// setSSEHeaders makes the response a stream of server-sent events. Only once the response is one for sure,
+// the errors written before are JSON.
+func setSSEHeaders(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+}
+
+// writeEvent writes a server-sent event, with the id field unless it is 0.
+// A data field is written per line of data, the client gets the lines joined by \n.
+func writeEvent(w io.Writer, id uint64, event string, data []byte) error {
+	var b bytes.Buffer
+	if id != 0 {
+		b.WriteString("id:" + strconv.FormatUint(id, 10) + "\n")
+	}
+	b.WriteString("event:" + event + "\n")
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		b.WriteString("data:")
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func send(line []byte, event string, s sink) {
 	// skip sending if no data
 	if len(line) == 0 {
 		return
 	}
 
+	if event == stdoutKey {
+		if rich, data, ok := richOutput(line); ok {
+			s.send(rich, data)
+			return
+		}
+	}
+
 	if event == stderrKey {
+		if name, ok := bytes.CutPrefix(line, []byte(violationPrefix)); ok {
+			s.send(violationEvent, name)
+			return
+		}
 		if shouldSkip(line) {
 			return
 		}
@@ -41,123 +125,217 @@ func send(line []byte, event string, c *gin.Context, lock *sync.Mutex, counter *
 		line = processError(line)
 	}
 
-	data := fmt.Sprintf("event:%s\ndata:%s\n\n", event, line)
+	s.send(event, line)
+}
 
-	// start sending with lock
-	lock.Lock()
-	defer lock.Unlock()
+// Execute uses SSE to stream the output of the command
+func Execute(c *gin.Context) {
+	req, ok := bindRequest(c)
+	if !ok {
+		return
+	}
 
-	if _, err := c.Writer.Write([]byte(data)); err != nil {
-		log.Printf("failed to send event to client: %s", err)
+	// nothing is written before the queue has accepted the request, the queued events start the stream
+	s := &sseSink{c: c}
+	release, err := acquire(c.Request.Context(), c.ClientIP(), s)
+	if err != nil {
+		if errors.Is(err, scheduler.ErrQueueFull) {
+			c.Header("Retry-After", strconv.Itoa(config.ExecuteRetryAfter))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, response{Error: err.Error()})
+		}
+		// otherwise the client has gone
 		return
 	}
-	c.Writer.Flush()
+	s.start()
+
+	// the run goes on if the client goes, it can follow it again with GET /executions/:id/events
+	e := registry.Start(func(e *execution.Execution) {
+		defer release()
 
-	*counter++
+		// the first event tells the client what to follow
+		e.Send(executionEvent, []byte(e.ID))
+		if err := run(e.Context(), req, sinkFunc(e.Send)); err != nil {
+			e.Send("error", []byte(err.Error()))
+		}
+	})
+	follow(c, e, 0)
 }
 
-// Execute uses SSE to stream the output of the command
-func Execute(c *gin.Context) {
+// bindRequest reads and checks the request of a run, the response is written if it is not valid.
+func bindRequest(c *gin.Context) (request, bool) {
 	var req request
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, response{
 			Error:   err.Error(),
 			Message: badRequestMessage,
 		})
-		return
+		return req, false
 	}
+	return req, checkRequest(c, req)
+}
 
-	var fileName = tmpFileName
-
-	env := os.Environ()
-	path := os.Getenv("PATH")
-	env = append(env, "PATH=/go/bin:"+path)
-
-	// create a tmp dir
-	tmpDir, err := os.MkdirTemp(baseDir+"/go", tmpDirName)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: fmt.Sprintf("Failed to create temp directory: %v", err)})
-		return
+// checkRequest reports whether the Go version, the profile and the backend of the request are known, the response is written if not.
+func checkRequest(c *gin.Context, req request) bool {
+	if _, err := toolchain.GoRoot(req.Version); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, response{
+			Error:   err.Error(),
+			Message: badRequestMessage,
+		})
+		return false
 	}
-	defer os.RemoveAll(tmpDir)
-
-	// write code to a file
-	codeFile := filepath.Join(tmpDir, fileName)
-	if err = os.WriteFile(codeFile, []byte(req.Code), 0644); err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: fmt.Sprintf("Failed to write code file: %v", err)})
-		return
+	if !config.SandboxProfiles[req.profile()] {
+		c.AbortWithStatusJSON(http.StatusBadRequest, response{
+			Error:   "unknown profile: " + req.profile(),
+			Message: badRequestMessage,
+		})
+		return false
 	}
+	if backends[req.backend()] == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, response{
+			Error:   "unknown backend: " + req.backend(),
+			Message: badRequestMessage,
+		})
+		return false
+	}
+	if req.Deterministic && req.backend() == wasmBackendName {
+		c.AbortWithStatusJSON(http.StatusBadRequest, response{
+			Error:   "deterministic runs are not supported by the wasm backend",
+			Message: badRequestMessage,
+		})
+		return false
+	}
+	if req.Pprof && req.backend() != nativeBackendName {
+		c.AbortWithStatusJSON(http.StatusBadRequest, response{
+			Error:   "profiles are only supported by the native backend",
+			Message: badRequestMessage,
+		})
+		return false
+	}
+	if req.Trace && req.backend() != nativeBackendName {
+		c.AbortWithStatusJSON(http.StatusBadRequest, response{
+			Error:   "traces are only supported by the native backend",
+			Message: badRequestMessage,
+		})
+		return false
+	}
+	return true
+}
 
-	cmd := exec.Command(sandboxRunner, codeFile)
-	cmd.Env = env
-
-	stdout, err := cmd.StdoutPipe()
+// run builds and runs the code in the sandbox, the output is sent to the sink until ctx is done.
+// An error is only returned if the execution could not be started, otherwise it ends with an error or done event.
+func run(ctx context.Context, req request, s sink) error {
+	ex := backends[req.backend()]
+	ws, err := ex.Prepare(ctx, req.Version, req.Code)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: fmt.Sprintf("Failed to get stdout pipe: %v", err)})
-		return
+		return err
 	}
-	defer stdout.Close()
-	stderr, err := cmd.StderrPipe()
+	defer ws.Release()
+
+	opts := executor.Options{Profile: req.profile(), Deterministic: req.Deterministic, Pprof: req.Pprof, Trace: req.Trace}
+	if req.Raw {
+		opts.Term = rawTerm
+	}
+	p, err := ex.Run(ctx, ws, opts)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: fmt.Sprintf("Failed to get stderr pipe: %v", err)})
-		return
+		return err
 	}
-	defer stderr.Close()
-
-	// setting headers for SSE
-	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	c.Writer.Header().Set("Cache-Control", "no-cache")
-	c.Writer.Header().Set("Connection", "keep-alive")
+	stdout, stderr := p.Stream()
+
+	// the backend ends the program and reports when it is stopped,
+	// when the client has gone or the output is over the budget
+	stop := sync.OnceFunc(p.Stop)
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop()
+		case <-exited:
+		}
+	}()
 
-	// a lock to protect c.Writer
 	var (
-		lock sync.Mutex
-		wg   sync.WaitGroup
+		wg    sync.WaitGroup
+		out   = newBudget(stop)
+		batch = newBatchSink(s)
 	)
 
-	if err = cmd.Start(); err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: fmt.Sprintf("Failed to start command: %v", err)})
-		return
-	}
-
 	wg.Add(2)
 
-	go stream(stdout, stdoutKey, c, &wg, &lock, cmd)
-	go stream(stderr, stderrKey, c, &wg, &lock, cmd)
-
-	// wait for both goroutines to finish
-	wg.Wait()
-
-	// wait for the command to finish
-	if err = cmd.Wait(); err != nil {
-		lock.Lock()
-		defer lock.Unlock()
-
-		// timeout case
-		if err.Error() == timeoutError {
-			c.Render(-1, render.Data{
-				Data: []byte(fmt.Sprintf("event:error\ndata:Execution timed out(%ds).\n\n", config.SandboxCPUTimeLimit)),
-			})
-			c.Writer.Flush()
-			return
-		}
+	if req.Deterministic {
+		s.send(playbackEvent, playbackNotice)
+		in := make(chan frame)
+		go readFrames(stdout, stdoutKey, in, &wg)
+		go readFrames(stderr, stderrKey, in, &wg)
+		go func() {
+			wg.Wait()
+			close(in)
+		}()
+		replay(ctx, in, batch, map[string]output{
+			stdoutKey: newOutput(stdoutKey, batch, req.Raw),
+			stderrKey: newOutput(stderrKey, batch, req.Raw),
+		}, out)
+	} else {
+		go stream(ctx, stdout, stdoutKey, newOutput(stdoutKey, batch, req.Raw), &wg, out)
+		go stream(ctx, stderr, stderrKey, newOutput(stderrKey, batch, req.Raw), &wg, out)
+
+		// wait for both goroutines to finish
+		wg.Wait()
+	}
+	batch.flush()
 
-		// other error cases
-		c.Render(-1, render.Data{
-			Data: []byte(fmt.Sprintf("event:error\ndata:%v\n\n", err)),
-		})
-		c.Writer.Flush()
-		return
+	// wait for the program to end, how it ended is in the result
+	res, err := p.Wait()
+	if err != nil {
+		log.Printf("failed to read the result: %s", err)
+		s.send("error", []byte(err.Error()))
+		return nil
+	}
+	if res.Truncated = out.over(); res.Truncated {
+		s.send(truncatedEvent, out.totals())
+	}
+	if b, e := json.Marshal(res); e == nil {
+		s.send(resultEvent, b)
+	}
+	if req.Pprof && res.Stage == protocol.StageRun {
+		sendProfiles(ctx, p.Artifacts(), s)
+	}
+	if req.Trace && res.Stage == protocol.StageRun {
+		sendTrace(ctx, p.Artifacts(), s)
 	}
 
-	lock.Lock()
-	defer lock.Unlock()
+	if msg := failure(res); msg != "" {
+		s.send("error", []byte(msg))
+		return nil
+	}
 
 	// lastly send done event
-	c.Render(-1, render.Data{
-		Data: []byte("event:done\ndata:Execution finished.\n\n"),
-	})
-	c.Writer.Flush()
+	s.send("done", []byte("Execution finished."))
+	return nil
+}
+
+// failure returns the error shown to the user for a run that did not succeed, empty otherwise.
+func failure(res *protocol.Result) string {
+	switch {
+	case res.OK():
+		return ""
+	case res.Stage == protocol.StageSetup:
+		return res.Error
+	case res.Stage == protocol.StageBuild:
+		return buildErrorMessage
+	case res.Truncated:
+		return "Execution stopped, the output is over the limit."
+	case res.Timeout == protocol.TimeoutWall:
+		return fmt.Sprintf("Execution timed out(%ds).", config.SandboxCPUTimeLimit)
+	case res.Timeout == protocol.TimeoutCPU:
+		return fmt.Sprintf("Execution exceeded the CPU time limit(%ds).", config.SandboxCPUTimeLimit)
+	case res.OOMKilled:
+		return "Execution ran out of memory."
+	case res.Signal != "":
+		return "Execution killed by signal: " + res.Signal
+	default:
+		return fmt.Sprintf("exit status %d", res.ExitCode)
+	}
 }
 
 var (
@@ -175,67 +353,32 @@ func processError(line []byte) []byte {
 	return errorRe.ReplaceAll(line, []byte(""))
 }
 
-func stream(r io.ReadCloser, event string, c *gin.Context, wg *sync.WaitGroup, lock *sync.Mutex, cmd *exec.Cmd) {
+// stream sends the output of r, the event stream, line by line within the budget of the run, the rest is read and discarded.
+func stream(ctx context.Context, r io.Reader, event string, lines output, wg *sync.WaitGroup, out *budget) {
 	defer wg.Done()
 
 	var (
-		buf     = make([]byte, chunkSize)
-		line    []byte
-		counter = 0
+		buf = make([]byte, chunkSize)
+		br  = budgetReader{r: r, stream: event, out: out}
 	)
 
 	for {
-		select {
-		case <-c.Request.Context().Done():
-			if err := cmd.Process.Kill(); err != nil {
-				log.Fatalf("failed to kill process: %s", err)
-			}
-			return
-		default:
-		}
-		if counter > config.ExecuteMaxEvents {
-			log.Printf("too many events, stop sending: %d", counter)
-			return
+		n, e := br.Read(buf)
+		// nothing is sent once the client has gone
+		if ctx.Err() == nil {
+			lines.write(buf[:n])
 		}
 
-		n, e := r.Read(buf)
-
-		if n == 0 {
-			if e != nil {
-				if e == io.EOF {
-					// just a double check, if context is not done, send remaining data
-					if c.Request.Context().Err() == nil {
-						// send remaining data if any
-						send(line, event, c, lock, &counter)
-					}
-					return
-				}
-				log.Printf("failed to read from %s: %s", event, e)
-				return // equal to break actually
+		if e != nil {
+			if e != io.EOF {
+				log.Printf("failed to read output: %s", e)
+				return
 			}
-			continue
-		}
-
-		b := buf[0]
-
-		switch b {
-		case '\r', '\n':
-			send(line, event, c, lock, &counter)
-			line = []byte{} // reset
-		case '\x0c':
-			// send remaining data first
-			send(line, event, c, lock, &counter)
-
-			// send clear event
-			lock.Lock()
-			c.Render(-1, render.Data{
-				Data: []byte("event:clear\ndata:\n\n"),
-			})
-			lock.Unlock()
-
-			line = []byte{} // reset
-		default:
-			line = append(line, b)
+			// send remaining data if any
+			if ctx.Err() == nil {
+				lines.close()
+			}
+			return
 		}
 	}
 }
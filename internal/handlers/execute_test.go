@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/executor"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/protocol"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	// the version of a request is checked against the installed toolchains, the one running the tests is
+	config.GoRoots[config.DefaultGoVersion] = runtime.GOROOT()
+	os.Exit(m.Run())
+}
+
+// sseEvent is an event of a response of server-sent events.
+type sseEvent struct {
+	id    uint64
+	event string
+	data  string
+}
+
+// newServer serves the routes of the runs, whose programs are run by fake.
+func newServer(t *testing.T, fake *executor.Fake) *httptest.Server {
+	t.Helper()
+
+	native := backends[nativeBackendName]
+	backends[nativeBackendName] = fake
+	t.Cleanup(func() { backends[nativeBackendName] = native })
+
+	r := gin.New()
+	r.POST("/execute", Execute)
+	r.POST("/executions", CreateExecution)
+	r.GET("/executions/:id", GetExecution)
+	r.DELETE("/executions/:id", CancelExecution)
+	r.GET("/executions/:id/events", ExecutionEvents)
+
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func post(t *testing.T, url string, body any) *http.Response {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(url, "application/json", strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+// readEvents reads the events of the response until it ends.
+func readEvents(t *testing.T, res *http.Response) []sseEvent {
+	t.Helper()
+	if res.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(res.Body)
+		t.Fatalf("status %s: %s", res.Status, b)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type is %q", ct)
+	}
+
+	var (
+		events  []sseEvent
+		ev      sseEvent
+		data    []string
+		scanner = bufio.NewScanner(res.Body)
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			ev.data = strings.Join(data, "\n")
+			events = append(events, ev)
+			ev, data = sseEvent{}, nil
+		case strings.HasPrefix(line, "id:"):
+			id, err := strconv.ParseUint(strings.TrimPrefix(line, "id:"), 10, 64)
+			if err != nil {
+				t.Fatalf("invalid id line %q", line)
+			}
+			ev.id = id
+		case strings.HasPrefix(line, "event:"):
+			ev.event = strings.TrimPrefix(line, "event:")
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(line, "data:"))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// joined returns the data of the events of the name joined by \n, the lines of a stream may come in several events.
+func joined(events []sseEvent, name string) string {
+	var lines []string
+	for _, ev := range events {
+		if ev.event == name {
+			lines = append(lines, ev.data)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func result(t *testing.T, events []sseEvent) protocol.Result {
+	t.Helper()
+	var res protocol.Result
+	if err := json.Unmarshal([]byte(joined(events, resultEvent)), &res); err != nil {
+		t.Fatalf("result event: %s", err)
+	}
+	return res
+}
+
+func TestExecute(t *testing.T) {
+	srv := newServer(t, &executor.Fake{Stdout: "hello\nworld\n", Stderr: "warning\n"})
+
+	events := readEvents(t, post(t, srv.URL+"/execute", map[string]string{"code": "package main"}))
+
+	if first := events[0]; first.event != executionEvent || first.data == "" {
+		t.Errorf("first event is %+v, want the ID of the execution", first)
+	}
+	for i, ev := range events {
+		if ev.id != uint64(i+1) {
+			t.Errorf("event %d %s has ID %d", i, ev.event, ev.id)
+		}
+	}
+	if got := joined(events, stdoutKey); got != "hello\nworld" {
+		t.Errorf("stdout is %q", got)
+	}
+	if got := joined(events, stderrKey); got != "warning" {
+		t.Errorf("stderr is %q", got)
+	}
+	if res := result(t, events); !res.OK() {
+		t.Errorf("result is %+v", res)
+	}
+	if last := events[len(events)-1]; last.event != "done" {
+		t.Errorf("last event is %+v, want done", last)
+	}
+}
+
+func TestExecuteExit(t *testing.T) {
+	srv := newServer(t, &executor.Fake{
+		Stdout: "partial\n",
+		Result: &protocol.Result{Stage: protocol.StageRun, ExitCode: 3},
+	})
+
+	events := readEvents(t, post(t, srv.URL+"/execute", map[string]string{"code": "package main"}))
+
+	if res := result(t, events); res.ExitCode != 3 {
+		t.Errorf("exit code is %d, want 3", res.ExitCode)
+	}
+	if got := joined(events, stdoutKey); got != "partial" {
+		t.Errorf("stdout is %q", got)
+	}
+	if last := events[len(events)-1]; last.event != "error" || last.data != "exit status 3" {
+		t.Errorf("last event is %+v, want the exit status", last)
+	}
+	if got := joined(events, "done"); got != "" {
+		t.Errorf("done event %q after a failed run", got)
+	}
+}
+
+func TestExecuteBuildFailed(t *testing.T) {
+	srv := newServer(t, &executor.Fake{
+		Stderr: "./main.go:1:1: expected 'package'\n",
+		Result: &protocol.Result{Stage: protocol.StageBuild, ExitCode: 1},
+	})
+
+	events := readEvents(t, post(t, srv.URL+"/execute", map[string]string{"code": "main"}))
+
+	if got := joined(events, stderrKey); !strings.Contains(got, "expected 'package'") {
+		t.Errorf("stderr is %q", got)
+	}
+	if last := events[len(events)-1]; last.event != "error" || last.data != buildErrorMessage {
+		t.Errorf("last event is %+v, want %q", last, buildErrorMessage)
+	}
+}
+
+func TestExecuteRunError(t *testing.T) {
+	srv := newServer(t, &executor.Fake{Err: errors.New("no runner is available")})
+
+	events := readEvents(t, post(t, srv.URL+"/execute", map[string]string{"code": "package main"}))
+
+	if last := events[len(events)-1]; last.event != "error" || last.data != "no runner is available" {
+		t.Errorf("last event is %+v, want the error of the executor", last)
+	}
+	if got := joined(events, resultEvent); got != "" {
+		t.Errorf("result event %q of a run that did not start", got)
+	}
+}
+
+func TestExecuteBadRequest(t *testing.T) {
+	srv := newServer(t, &executor.Fake{})
+
+	for name, body := range map[string]map[string]any{
+		"no code":         {"version": "1"},
+		"unknown version": {"code": "package main", "version": "0.1"},
+		"unknown profile": {"code": "package main", "profile": "none"},
+		"unknown backend": {"code": "package main", "backend": "none"},
+		"pprof on wasm":   {"code": "package main", "backend": wasmBackendName, "pprof": true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := post(t, srv.URL+"/execute", body)
+			if res.StatusCode != http.StatusBadRequest {
+				t.Fatalf("status %s, want 400", res.Status)
+			}
+			var r response
+			if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+				t.Fatal(err)
+			}
+			if r.Error == "" || r.Message != badRequestMessage {
+				t.Errorf("response is %+v", r)
+			}
+		})
+	}
+}
+
+func TestExecutionEventsResume(t *testing.T) {
+	srv := newServer(t, &executor.Fake{Stdout: "one\n", Stderr: "two\n"})
+
+	all := readEvents(t, post(t, srv.URL+"/execute", map[string]string{"code": "package main"}))
+	if len(all) < 4 {
+		t.Fatalf("only %d events", len(all))
+	}
+	id := all[0].data
+
+	get := func(lastEventID string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/executions/"+id+"/events", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastEventID != "" {
+			req.Header.Set(lastEventIDKey, lastEventID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	// the ended execution is kept, it is followed again from the start or after the last event received
+	for _, after := range []int{0, 1, len(all) - 2} {
+		lastEventID := ""
+		if after > 0 {
+			lastEventID = strconv.FormatUint(all[after-1].id, 10)
+		}
+		got := readEvents(t, get(lastEventID))
+		want := all[after:]
+		if len(got) != len(want) {
+			t.Fatalf("after %q: %d events, want %d", lastEventID, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("after %q: event %d is %+v, want %+v", lastEventID, i, got[i], want[i])
+			}
+		}
+	}
+
+	if res := get("x"); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid %s: status %s, want 400", lastEventIDKey, res.Status)
+	}
+	id = "unknown"
+	if res := get(""); res.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown execution: status %s, want 404", res.Status)
+	}
+}
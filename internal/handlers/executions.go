@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/execution"
+)
+
+const (
+	executionEvent = "execution" // the ID of the execution, the first event of a run
+	missedEvent    = "missed"    // the number of events that are no longer kept, before the ones that are
+	lastEventIDKey = "Last-Event-ID"
+)
+
+var registry = execution.NewRegistry(config.ExecutionBufferSize, config.ExecutionGracePeriod*time.Second)
+
+// ExecutionEvents follows the execution of the id param, from the event after the Last-Event-ID header.
+func ExecutionEvents(c *gin.Context) {
+	e, ok := registry.Get(c.Param("id"))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, response{Error: "execution not found"})
+		return
+	}
+	var after uint64
+	if id := c.GetHeader(lastEventIDKey); id != "" {
+		n, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, response{
+				Error:   "invalid " + lastEventIDKey + ": " + id,
+				Message: badRequestMessage,
+			})
+			return
+		}
+		after = n
+	}
+
+	setSSEHeaders(c)
+	follow(c, e, after)
+}
+
+// follow writes the events of the execution after the ID to the client, until the execution ends or the client goes.
+func follow(c *gin.Context, e *execution.Execution, after uint64) {
+	unfollow := e.Follow()
+	defer unfollow()
+
+	for {
+		events, missed, done, changed := e.Events(after)
+		if missed > 0 {
+			if err := writeEvent(c.Writer, 0, missedEvent, []byte(strconv.FormatUint(missed, 10))); err != nil {
+				log.Printf("failed to send event to client: %s", err)
+				return
+			}
+		}
+		for _, ev := range events {
+			if err := writeEvent(c.Writer, ev.ID, ev.Name, ev.Data); err != nil {
+				log.Printf("failed to send event to client: %s", err)
+				return
+			}
+			after = ev.ID
+		}
+		c.Writer.Flush()
+
+		if done && len(events) == 0 {
+			return
+		}
+		if len(events) > 0 {
+			continue
+		}
+		select {
+		case <-changed:
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
@@ -1,29 +1,38 @@
 package handlers
 
 import (
-	"fmt"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/toolchain"
+	"io/fs"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
 var (
-	validPath = regexp.MustCompile(`^file://[0-9A-Za-z._\-/:]+$`)
+	validPath = regexp.MustCompile(`^file://[0-9A-Za-z._\-/:@!~+]+$`)
 )
 
 type fetchSourceRes struct {
-	Error   string `json:"error,omitempty"`
-	Content string `json:"content,omitempty"`
-	IsMain  bool   `json:"is_main"`
+	Error      string `json:"error,omitempty"`
+	Content    string `json:"content,omitempty"`
+	IsMain     bool   `json:"is_main"`
+	StartLine  int    `json:"start_line,omitempty"`
+	EndLine    int    `json:"end_line,omitempty"`
+	TotalLines int    `json:"total_lines,omitempty"`
 }
 
 // FetchSource handles the request to fetch the source code from a given path.
+// The optional start and end query parameters (1-based, inclusive) limit the content to a range of lines.
 func FetchSource(c *gin.Context) {
 	var (
 		path    = c.Query("path")
-		// version = c.Query("version")
+		version = c.Query("version")
 	)
 
 	if path == "" {
@@ -36,27 +45,82 @@ func FetchSource(c *gin.Context) {
 		return
 	}
 
-	path = strings.TrimPrefix(path, "file://")
+	// cleaned first, so that neither "/app/sandboxes/go/../.." nor "/app/sandboxes/gofoo" is the main file
+	path = filepath.Clean(strings.TrimPrefix(path, "file://"))
 
 	// check if it is the main file
-	if strings.HasPrefix(path, "/app/sandboxes/go") {
+	if strings.HasPrefix(path, config.SandboxPath+"/") {
 		c.JSON(http.StatusOK, fetchSourceRes{IsMain: true})
 		return
 	}
 
-	// if !strings.HasPrefix(path, "/go/src") {
-	// 	c.JSON(http.StatusBadRequest, fetchSourceRes{Error: "path must be in /usr/local/go"})
-	// 	return
-	// }
+	start, end, err := lineRange(c.Query("start"), c.Query("end"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, fetchSourceRes{Error: err.Error()})
+		return
+	}
+
+	resolved, err := toolchain.ResolveSource(path, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, toolchain.ErrUnknownVersion), errors.Is(err, toolchain.ErrOutsideRoots):
+			c.JSON(http.StatusBadRequest, fetchSourceRes{Error: err.Error()})
+		case errors.Is(err, fs.ErrNotExist):
+			c.JSON(http.StatusNotFound, fetchSourceRes{Error: "file not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, fetchSourceRes{Error: err.Error()})
+		}
+		return
+	}
 
-	content, err := os.ReadFile(path)
+	content, err := os.ReadFile(resolved)
 	if err != nil {
-		c.String(http.StatusInternalServerError, fmt.Sprintf("failed to read file: %s", err.Error()))
+		c.JSON(http.StatusInternalServerError, fetchSourceRes{Error: "failed to read file: " + err.Error()})
+		return
+	}
+
+	// whole file
+	if start == 0 {
+		c.JSON(http.StatusOK, fetchSourceRes{
+			Content: string(content),
+		})
+		return
+	}
+
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if end == 0 || end > len(lines) {
+		end = len(lines)
+	}
+	if start > end {
+		c.JSON(http.StatusBadRequest, fetchSourceRes{Error: "start is beyond the end of file"})
 		return
 	}
-	// Set the content type to text/plain
-	c.Header("Content-Type", "text/plain")
 	c.JSON(http.StatusOK, fetchSourceRes{
-		Content: string(content),
+		Content:    strings.Join(lines[start-1:end], ""),
+		StartLine:  start,
+		EndLine:    end,
+		TotalLines: len(lines),
 	})
 }
+
+// lineRange parses the optional line range, zero means not set.
+func lineRange(startQuery, endQuery string) (start, end int, err error) {
+	if startQuery == "" && endQuery == "" {
+		return 0, 0, nil
+	}
+	start = 1
+	if startQuery != "" {
+		if start, err = strconv.Atoi(startQuery); err != nil || start < 1 {
+			return 0, 0, errors.New("invalid start line")
+		}
+	}
+	if endQuery != "" {
+		if end, err = strconv.Atoi(endQuery); err != nil || end < start {
+			return 0, 0, errors.New("invalid end line")
+		}
+	}
+	return start, end, nil
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/execution"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/protocol"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/scheduler"
+)
+
+// jobs keeps the state of the runs started with POST /executions, another store may be set before serving.
+var jobs execution.Store = execution.NewMemoryStore(config.JobRetention * time.Second)
+
+// CreateExecution starts a run of the code without waiting for it, it responds with the job
+// that GET /executions/:id returns until the run has ended. The events can be followed as well.
+func CreateExecution(c *gin.Context) {
+	req, ok := bindRequest(c)
+	if !ok {
+		return
+	}
+
+	client := c.ClientIP()
+	accepted := make(chan error, 1)
+	e := registry.Start(func(e *execution.Execution) {
+		// the job follows its own run, so that it is not stopped for having no other followers
+		defer e.Follow()()
+
+		j := newJobSink(e.ID)
+		s := sinkFunc(func(event string, data []byte) {
+			e.Send(event, data)
+			j.send(event, data)
+		})
+
+		// the job is saved once the queue has accepted it
+		var once sync.Once
+		accept := func() {
+			once.Do(func() { accepted <- j.save() })
+		}
+		release, err := acquire(e.Context(), client, sinkFunc(func(event string, data []byte) {
+			accept()
+			s.send(event, data)
+		}))
+		if errors.Is(err, scheduler.ErrQueueFull) {
+			accepted <- err
+			return
+		}
+		accept()
+		if err != nil {
+			// canceled while queued
+			j.end(e.Canceled())
+			return
+		}
+		defer release()
+
+		j.start()
+		if err := run(e.Context(), req, s); err != nil {
+			s.send("error", []byte(err.Error()))
+		}
+		j.end(e.Canceled())
+	})
+
+	if err := <-accepted; err != nil {
+		if errors.Is(err, scheduler.ErrQueueFull) {
+			c.Header("Retry-After", strconv.Itoa(config.ExecuteRetryAfter))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, response{Error: err.Error()})
+			return
+		}
+		e.Cancel()
+		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: err.Error()})
+		return
+	}
+	job, ok := getJob(c, e.ID)
+	if !ok {
+		return
+	}
+	c.Header("Location", "/executions/"+e.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetExecution returns the job of the id param, with the output so far.
+func GetExecution(c *gin.Context) {
+	if job, ok := getJob(c, c.Param("id")); ok {
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+// CancelExecution stops the run of the job of the id param, the job is canceled once it has stopped.
+func CancelExecution(c *gin.Context) {
+	job, ok := getJob(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	if job.Ended() {
+		c.AbortWithStatusJSON(http.StatusConflict, response{Error: "the execution has ended"})
+		return
+	}
+	if e, ok := registry.Get(job.ID); ok {
+		e.Cancel()
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+// getJob returns the job of the ID, the response is written if there is none.
+func getJob(c *gin.Context, id string) (execution.Job, bool) {
+	job, err := jobs.Get(c.Request.Context(), id)
+	if errors.Is(err, execution.ErrJobNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, response{Error: "execution not found"})
+		return job, false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: err.Error()})
+		return job, false
+	}
+	return job, true
+}
+
+// jobSink records the events of a run in its job, the output is saved at most every JobSaveInterval
+// while it runs. Rich output is not kept, only text.
+type jobSink struct {
+	lock           sync.Mutex
+	job            execution.Job
+	stdout, stderr []byte
+	saved          time.Time
+	timer          *time.Timer // saves the output that came since
+}
+
+func newJobSink(id string) *jobSink {
+	return &jobSink{job: execution.Job{ID: id, Status: execution.JobQueued, Created: time.Now()}}
+}
+
+func (j *jobSink) send(event string, data []byte) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	switch event {
+	case stdoutKey:
+		j.stdout = append(append(j.stdout, data...), '\n')
+	case stderrKey:
+		j.stderr = append(append(j.stderr, data...), '\n')
+	case rawEvent:
+		raw, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return
+		}
+		j.stdout = append(j.stdout, raw...)
+	case resultEvent:
+		var res protocol.Result
+		if err := json.Unmarshal(data, &res); err != nil {
+			return
+		}
+		j.job.Result = &res
+	case "error":
+		j.job.Error = string(data)
+	case pprofEvent:
+		j.job.Pprof = append(json.RawMessage(nil), data...)
+	case traceEvent:
+		j.job.Trace = append(json.RawMessage(nil), data...)
+	default:
+		return
+	}
+	if wait := config.JobSaveInterval*time.Second - time.Since(j.saved); wait <= 0 {
+		j.saveLocked()
+	} else if j.timer == nil {
+		j.timer = time.AfterFunc(wait, func() { j.save() })
+	}
+}
+
+func (j *jobSink) save() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.saveLocked()
+}
+
+func (j *jobSink) saveLocked() error {
+	if j.timer != nil {
+		j.timer.Stop()
+		j.timer = nil
+	}
+	j.saved = time.Now()
+	j.job.Stdout, j.job.Stderr = string(j.stdout), string(j.stderr)
+	// the job may end after the client has gone, so the store does not get a request context
+	if err := jobs.Put(context.Background(), j.job); err != nil {
+		log.Printf("failed to save job %s: %s", j.job.ID, err)
+		return err
+	}
+	return nil
+}
+
+func (j *jobSink) start() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	now := time.Now()
+	j.job.Status = execution.JobRunning
+	j.job.Started = &now
+	j.saveLocked()
+}
+
+func (j *jobSink) end(canceled bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	now := time.Now()
+	switch {
+	case canceled:
+		j.job.Status = execution.JobCanceled
+	case j.job.Result != nil && j.job.Result.OK():
+		j.job.Status = execution.JobDone
+	default:
+		j.job.Status = execution.JobFailed
+	}
+	j.job.Finished = &now
+	j.saveLocked()
+}
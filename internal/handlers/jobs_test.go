@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/execution"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/executor"
+)
+
+func do(t *testing.T, method, url string) (*http.Response, execution.Job) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var job execution.Job
+	if res.StatusCode < 300 {
+		if err = json.NewDecoder(res.Body).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res, job
+}
+
+// waitJob polls the job until it has ended.
+func waitJob(t *testing.T, url string) execution.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, job := do(t, http.MethodGet, url)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status %s", url, res.Status)
+		}
+		if job.Ended() {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the job is still %s", job.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func createJob(t *testing.T, url string) execution.Job {
+	t.Helper()
+	res := post(t, url+"/executions", map[string]string{"code": "package main"})
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("status %s, want 202", res.Status)
+	}
+	var job execution.Job
+	if err := json.NewDecoder(res.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if job.ID == "" || job.Ended() {
+		t.Fatalf("created job is %+v", job)
+	}
+	if loc := res.Header.Get("Location"); loc != "/executions/"+job.ID {
+		t.Errorf("Location is %q", loc)
+	}
+	return job
+}
+
+func TestJob(t *testing.T) {
+	srv := newServer(t, &executor.Fake{Stdout: "hello\n", Stderr: "warning\n"})
+
+	job := waitJob(t, srv.URL+"/executions/"+createJob(t, srv.URL).ID)
+
+	if job.Status != execution.JobDone {
+		t.Errorf("status is %s, want %s", job.Status, execution.JobDone)
+	}
+	if job.Stdout != "hello\n" || job.Stderr != "warning\n" {
+		t.Errorf("output is %q and %q", job.Stdout, job.Stderr)
+	}
+	if job.Result == nil || !job.Result.OK() {
+		t.Errorf("result is %+v", job.Result)
+	}
+	if job.Started == nil || job.Finished == nil {
+		t.Errorf("started %v, finished %v", job.Started, job.Finished)
+	}
+
+	// an ended job cannot be canceled
+	if res, _ := do(t, http.MethodDelete, srv.URL+"/executions/"+job.ID); res.StatusCode != http.StatusConflict {
+		t.Errorf("DELETE of an ended job: status %s, want 409", res.Status)
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	hold := make(chan struct{})
+	defer close(hold)
+	srv := newServer(t, &executor.Fake{Stdout: "never\n", Hold: hold})
+
+	url := srv.URL + "/executions/" + createJob(t, srv.URL).ID
+
+	res, job := do(t, http.MethodDelete, url)
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("DELETE: status %s, want 202", res.Status)
+	}
+	if job.Ended() {
+		t.Errorf("the job has ended before it was stopped: %+v", job)
+	}
+
+	job = waitJob(t, url)
+	if job.Status != execution.JobCanceled {
+		t.Errorf("status is %s, want %s", job.Status, execution.JobCanceled)
+	}
+	if job.Stdout != "" {
+		t.Errorf("stdout of a canceled run is %q", job.Stdout)
+	}
+}
+
+func TestJobNotFound(t *testing.T) {
+	srv := newServer(t, &executor.Fake{})
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if res, _ := do(t, method, srv.URL+"/executions/unknown"); res.StatusCode != http.StatusNotFound {
+			t.Errorf("%s of an unknown job: status %s, want 404", method, res.Status)
+		}
+	}
+}
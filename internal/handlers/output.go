@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+)
+
+const (
+	clearEvent = "clear"
+	// chunks of stdout as the program wrote them, base64-encoded, for a terminal emulator
+	rawEvent = "output-raw"
+	// the terminal the program is told it writes to in raw mode
+	rawTerm = "xterm-256color"
+	// the bytes that end a line of the splitter
+	lineEnds = "\r\n\x0c"
+)
+
+// output sends the output of a stream as events.
+type output interface {
+	write(data []byte)
+	close() // the stream has ended
+}
+
+// newOutput returns the output of the stream event, stdout is passed through as it is if raw.
+func newOutput(event string, s sink, raw bool) output {
+	if raw && event == stdoutKey {
+		return rawOutput{s: s}
+	}
+	return &splitter{event: event, s: s}
+}
+
+// rawOutput sends the output unchanged, escape sequences, \r and \x0c are left to the client.
+// The chunks are encoded by the batchSink it sends to.
+type rawOutput struct {
+	s sink
+}
+
+func (r rawOutput) write(data []byte) {
+	if len(data) > 0 {
+		r.s.send(rawEvent, data)
+	}
+}
+
+func (rawOutput) close() {}
+
+// splitter cuts the output of a stream into lines at \n and \r, \x0c ends the line and clears the screen.
+// The unfinished line is kept until more output comes or the stream is closed.
+type splitter struct {
+	event string
+	s     sink
+	line  []byte
+}
+
+func (p *splitter) write(data []byte) {
+	for len(data) > 0 {
+		i := bytes.IndexAny(data, lineEnds)
+		if i < 0 {
+			p.line = append(p.line, data...)
+			return
+		}
+		p.line = append(p.line, data[:i]...)
+		send(p.line, p.event, p.s)
+		if data[i] == '\x0c' {
+			p.s.send(clearEvent, nil)
+		}
+		p.line = p.line[:0]
+		data = data[i+1:]
+	}
+}
+
+// close sends the unfinished line if any.
+func (p *splitter) close() {
+	send(p.line, p.event, p.s)
+	p.line = nil
+}
+
+// budgetReader reads the output of the stream within the budget, the rest is read and discarded.
+type budgetReader struct {
+	r      io.Reader
+	stream string
+	out    *budget
+}
+
+func (b budgetReader) Read(p []byte) (int, error) {
+	for {
+		n, err := b.r.Read(p)
+		n = len(b.out.take(b.stream, p[:n]))
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// batchSink coalesces the consecutive lines of a stream into one event, a line per data field of the SSE,
+// and the consecutive raw chunks into one, which is encoded when it is sent.
+// The batch is sent when another event comes, when it is large enough or on the flush interval,
+// so a program printing many short lines costs one write and flush per batch instead of per line.
+type batchSink struct {
+	next  sink
+	lock  sync.Mutex
+	event string
+	batch []byte // lines separated by \n, or raw chunks
+	timer *time.Timer
+}
+
+func newBatchSink(next sink) *batchSink {
+	return &batchSink{next: next}
+}
+
+func (b *batchSink) send(event string, data []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if event != stdoutKey && event != stderrKey && event != rawEvent {
+		b.flushLocked()
+		b.next.send(event, data)
+		return
+	}
+	if event != b.event {
+		b.flushLocked()
+		b.event = event
+	}
+	if len(b.batch) > 0 && event != rawEvent {
+		b.batch = append(b.batch, '\n')
+	}
+	b.batch = append(b.batch, data...)
+
+	if len(b.batch) >= config.ExecuteBatchSize {
+		b.flushLocked()
+	} else if b.timer == nil {
+		b.timer = time.AfterFunc(config.ExecuteFlushInterval*time.Millisecond, b.flush)
+	}
+}
+
+// flush sends the pending batch, it has to be called when the output ends.
+func (b *batchSink) flush() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.flushLocked()
+}
+
+func (b *batchSink) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.batch) == 0 {
+		return
+	}
+	if b.event == rawEvent {
+		b.next.send(b.event, []byte(base64.StdEncoding.EncodeToString(b.batch)))
+	} else {
+		b.next.send(b.event, b.batch)
+	}
+	b.batch = b.batch[:0]
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+)
+
+const (
+	// a program built with -tags=faketime writes its output in frames: 0 0 P B <8-byte time> <4-byte length> <data>
+	playbackHeader    = "\x00\x00PB"
+	playbackHeaderLen = 16
+	clockEvent        = "clock"
+	playbackEvent     = "playback"
+	// frames of both streams are read separately, the ones that arrive within this window are put back in order
+	playbackReorderWindow = 20 * time.Millisecond
+)
+
+// playbackNotice tells the client what the fake clock makes reproducible: the time and the seed of the top-level
+// functions of math/rand. math/rand/v2, crypto/rand, map iteration and select are seeded by the kernel as usual.
+var playbackNotice = []byte(`{"fixed":["time","math/rand"],"random":["math/rand/v2","crypto/rand","map iteration","select"]}`)
+
+// frame is a piece of output of a faketime program at its virtual time.
+// Output that is not framed, e.g. of the build, gets the time of the last frame of its stream.
+type frame struct {
+	event string
+	time  int64 // nanoseconds since 1970
+	data  []byte
+	seq   int  // keeps the order of arrival for the same time
+	eof   bool // the stream has ended
+}
+
+// readFrames decodes the output of a faketime program until r is closed.
+func readFrames(r io.Reader, event string, out chan<- frame, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer func() { out <- frame{event: event, eof: true} }()
+
+	var (
+		br   = bufio.NewReader(r)
+		last int64
+	)
+	for {
+		header, err := br.Peek(len(playbackHeader))
+		if err == nil && string(header) == playbackHeader {
+			var h [playbackHeaderLen]byte
+			if _, err = io.ReadFull(br, h[:]); err != nil {
+				return
+			}
+			data := make([]byte, binary.BigEndian.Uint32(h[12:]))
+			if _, err = io.ReadFull(br, data); err != nil {
+				return
+			}
+			last = int64(binary.BigEndian.Uint64(h[4:]))
+			out <- frame{event: event, time: last, data: data}
+			continue
+		}
+
+		// plain output, up to the end of line or the next frame
+		data, err := readPlain(br)
+		if len(data) > 0 {
+			out <- frame{event: event, time: last, data: data}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func readPlain(br *bufio.Reader) ([]byte, error) {
+	var data []byte
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return data, err
+		}
+		data = append(data, b)
+		if b == '\n' {
+			return data, nil
+		}
+		if next, e := br.Peek(1); e == nil && next[0] == playbackHeader[0] {
+			if h, e := br.Peek(len(playbackHeader)); e == nil && string(h) == playbackHeader {
+				return data, nil
+			}
+		}
+	}
+}
+
+// frames orders the frames by time.
+type frames []frame
+
+func (f frames) Len() int { return len(f) }
+func (f frames) Less(i, j int) bool {
+	return f[i].time < f[j].time || f[i].time == f[j].time && f[i].seq < f[j].seq
+}
+func (f frames) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f *frames) Push(x any)   { *f = append(*f, x.(frame)) }
+func (f *frames) Pop() any {
+	old := *f
+	x := old[len(old)-1]
+	*f = old[:len(old)-1]
+	return x
+}
+
+// replay sends the frames to the outputs of their streams in the order of the virtual time of the program,
+// waiting as long as the program did between them. A clock event with the virtual time is sent to s before new output.
+// Each stream is in order by itself, so the earliest frame can go once the other stream has a frame or is closed,
+// otherwise it waits a little for the other stream to catch up. Only the output within the budget is sent.
+func replay(ctx context.Context, in <-chan frame, s sink, lines map[string]output, out *budget) {
+	var (
+		pending frames
+		queued  = make(map[string]int)  // frames pending per stream
+		closed  = make(map[string]bool) // streams that have ended
+		seq     int
+		last    int64 // virtual time of the last frame sent
+		open    = true
+	)
+
+	receive := func(f frame, ok bool) {
+		switch {
+		case !ok:
+			open = false
+		case f.eof:
+			closed[f.event] = true
+		default:
+			f.seq = seq
+			seq++
+			queued[f.event]++
+			heap.Push(&pending, f)
+		}
+	}
+	// ready reports whether no frame earlier than the first pending one can arrive
+	ready := func() bool {
+		for _, event := range []string{stdoutKey, stderrKey} {
+			if !closed[event] && queued[event] == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	for open || len(pending) > 0 {
+		if open && len(pending) == 0 {
+			select {
+			case f, ok := <-in:
+				receive(f, ok)
+			case <-ctx.Done():
+			}
+			continue
+		}
+		if open && !ready() && ctx.Err() == nil {
+			timer := time.NewTimer(playbackReorderWindow)
+		window:
+			for open && !ready() {
+				select {
+				case f, ok := <-in:
+					receive(f, ok)
+				case <-timer.C:
+					break window
+				case <-ctx.Done():
+					break window
+				}
+			}
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			// let the readers finish
+			for range in {
+			}
+			return
+		}
+
+		f := heap.Pop(&pending).(frame)
+		queued[f.event]--
+		data := out.take(f.event, f.data)
+		if len(data) == 0 {
+			continue
+		}
+		if f.time > last {
+			if last > 0 {
+				delay := min(time.Duration(f.time-last), config.PlaybackMaxDelay*time.Second)
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					continue
+				}
+			}
+			last = f.time
+			s.send(clockEvent, []byte(time.Unix(0, last).UTC().Format(time.RFC3339Nano)))
+		}
+		lines[f.event].write(data)
+	}
+
+	// send remaining data if any
+	for _, event := range []string{stdoutKey, stderrKey} {
+		lines[event].close()
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/db"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/executor"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/profiling"
+)
+
+const (
+	pprofEvent     = "pprof"  // the summaries of the profiles of a run, after the result event
+	pprofKeyPrefix = "pprof/" // of the profiles in the snippet bucket
+)
+
+// the profiles of a run by their key in the pprof event, and the sample type summarized.
+// The heap profile counts what was allocated during the run, little is left at its end.
+var pprofProfiles = []struct {
+	key, artifact, sampleType string
+}{
+	{"cpu", executor.CPUProfile, "cpu"},
+	{"heap", executor.HeapProfile, "alloc_space"},
+}
+
+type pprofSummary struct {
+	*profiling.Summary
+	URL   string `json:"url,omitempty"`   // of the raw profile, for go tool pprof
+	Error string `json:"error,omitempty"` // why there is no summary
+}
+
+// sendProfiles summarizes the profiles of a run and stores them with the snippets. The summaries are sent
+// as a pprof event, a program that exits without returning from main, or is killed, has none.
+// A profile larger than config.ArtifactMaxSize only has an error.
+func sendProfiles(ctx context.Context, artifacts map[string]executor.Artifact, s sink) {
+	// the profiles are stored even if the client has gone, it can follow the run again
+	ctx = context.WithoutCancel(ctx)
+	summaries := map[string]pprofSummary{}
+	for _, p := range pprofProfiles {
+		a, ok := artifacts[p.artifact]
+		if !ok {
+			continue
+		}
+		if a.TooLarge() {
+			log.Printf("the %s profile is too large: %d bytes", p.key, a.Size)
+			summaries[p.key] = pprofSummary{Error: fmt.Sprintf("the profile is %d bytes, more than %d", a.Size, config.ArtifactMaxSize)}
+			continue
+		}
+		data := a.Data
+		summary, err := profiling.Summarize(data, p.sampleType, config.PprofTopN)
+		if err != nil {
+			log.Printf("failed to read the %s profile: %s", p.key, err)
+			continue
+		}
+		sum := sha256.Sum256(data)
+		id := hex.EncodeToString(sum[:])
+		url := "/pprof/" + id
+		if err = db.S3().PutObject(ctx, pprofKeyPrefix+id, data); err != nil {
+			log.Printf("failed to store the %s profile: %s", p.key, err)
+			url = ""
+		}
+		summaries[p.key] = pprofSummary{Summary: summary, URL: url}
+	}
+	if b, err := json.Marshal(summaries); err == nil {
+		s.send(pprofEvent, b)
+	}
+}
+
+// FetchPprof returns the profile of the id param of a run, in the gzipped protobuf format of go tool pprof.
+func FetchPprof(c *gin.Context) {
+	id := c.Param("id")
+	if !contentIDRe.MatchString(id) {
+		c.AbortWithStatusJSON(http.StatusNotFound, response{Error: "profile not found"})
+		return
+	}
+	data, err := db.S3().GetObject(c, pprofKeyPrefix+id)
+	if err != nil {
+		if errors.Is(err, db.ErrObjectNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, response{Error: "profile not found"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: err.Error()})
+		return
+	}
+	// the ID is the hash of the content, it never changes
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("Content-Disposition", `attachment; filename="`+id+`.pb.gz"`)
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
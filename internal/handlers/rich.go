@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+)
+
+// a line of stdout with one of these prefixes is shown as something other than text,
+// the display module in the sandbox module cache writes them, see display/display.go
+const (
+	richOutputPrefix = "SANDBOX_OUTPUT:" // followed by a richMessage
+	imagePrefix      = "IMAGE:"          // followed by a base64-encoded PNG, like on the Go playground
+)
+
+// the events of rich output
+const (
+	imageEvent = "image" // imageData
+	htmlEvent  = "html"  // the HTML fragment
+	tableEvent = "table" // richTable
+)
+
+// the image types a program may show, the client renders them as images only
+var imageTypes = map[string]bool{
+	"image/png":     true,
+	"image/jpeg":    true,
+	"image/gif":     true,
+	"image/svg+xml": true,
+}
+
+type richMessage struct {
+	Type string          `json:"type"`
+	MIME string          `json:"mime"`
+	Data json.RawMessage `json:"data"`
+}
+
+type imageData struct {
+	MIME string `json:"mime"`
+	Data string `json:"data"` // base64
+}
+
+type richTable struct {
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+// richOutput returns the event of a line of stdout that is rich output.
+// A line that only looks like one is not, it is shown as text.
+func richOutput(line []byte) (event string, data []byte, ok bool) {
+	if b64, found := bytes.CutPrefix(line, []byte(imagePrefix)); found {
+		return imageOutput("image/png", string(b64))
+	}
+	msg, found := bytes.CutPrefix(line, []byte(richOutputPrefix))
+	if !found {
+		return "", nil, false
+	}
+
+	var m richMessage
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return "", nil, false
+	}
+	switch m.Type {
+	case imageEvent:
+		var b64 string
+		if err := json.Unmarshal(m.Data, &b64); err != nil {
+			return "", nil, false
+		}
+		return imageOutput(m.MIME, b64)
+	case htmlEvent:
+		var html string
+		if err := json.Unmarshal(m.Data, &html); err != nil {
+			return "", nil, false
+		}
+		return htmlEvent, []byte(html), true
+	case tableEvent:
+		var t richTable
+		if err := json.Unmarshal(m.Data, &t); err != nil || !t.valid() {
+			return "", nil, false
+		}
+		if t.Rows == nil {
+			t.Rows = [][]any{} // the client maps the rows
+		}
+		data, err := json.Marshal(t)
+		return tableEvent, data, err == nil
+	default:
+		return "", nil, false
+	}
+}
+
+func imageOutput(mime, b64 string) (string, []byte, bool) {
+	if !imageTypes[mime] {
+		return "", nil, false
+	}
+	if _, err := base64.StdEncoding.DecodeString(b64); err != nil {
+		return "", nil, false
+	}
+	data, err := json.Marshal(imageData{MIME: mime, Data: b64})
+	return imageEvent, data, err == nil
+}
+
+// valid reports whether the table has columns and a value per column in each row, the client renders no other table.
+func (t *richTable) valid() bool {
+	if len(t.Columns) == 0 {
+		return false
+	}
+	for _, row := range t.Rows {
+		if len(row) != len(t.Columns) {
+			return false
+		}
+	}
+	return true
+}
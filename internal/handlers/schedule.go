@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/scheduler"
+)
+
+const queuedKey = "queued"
+
+var executions = scheduler.New(
+	config.Int(config.ExecuteMaxConcurrentKey, config.ExecuteMaxConcurrent),
+	config.Int(config.ExecuteMaxQueuedKey, config.ExecuteMaxQueued),
+	config.Int(config.ExecuteMaxQueuedClientKey, config.ExecuteMaxQueuedClient),
+)
+
+// acquire waits for an execution slot of the client, the queue position is sent to the sink while waiting.
+func acquire(ctx context.Context, client string, s sink) (func(), error) {
+	return executions.Acquire(ctx, client, func(pos int) {
+		s.send(queuedKey, []byte(strconv.Itoa(pos)))
+	})
+}
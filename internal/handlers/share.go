@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
@@ -26,26 +27,34 @@ func ShareSnippet(c *gin.Context) {
 		return
 	}
 
+	key, err := saveSnippet(c, req.Code)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: err.Error()})
+		return
+	}
+
+	c.String(http.StatusOK, key)
+}
+
+// saveSnippet stores the code in S3 unless it exists already, and returns its key.
+func saveSnippet(ctx context.Context, code string) (string, error) {
 	// Generate a hash-based key from the code snippet.
-	key := generateHashKey([]byte(req.Code))
+	key := generateHashKey([]byte(code))
 
 	// Check if the snippet already exists in S3.
-	_, err := db.S3().GetObject(c, key)
+	_, err := db.S3().GetObject(ctx, key)
 	if err != nil {
 		if errors.Is(err, db.ErrObjectNotFound) {
 			// Save the snippet in S3.
-			if e := db.S3().PutObject(c, key, []byte(req.Code)); e != nil {
-				c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: e.Error()})
-				return
+			if e := db.S3().PutObject(ctx, key, []byte(code)); e != nil {
+				return "", e
 			}
-			goto done
+			return key, nil
 		}
 
 		// Handle other errors.
-		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: err.Error()})
-		return
+		return "", err
 	}
 
-done:
-	c.String(http.StatusOK, key)
+	return key, nil
 }
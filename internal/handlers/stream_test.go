@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// benchLines is the number of lines of the output of the benchmarks
+const benchLines = 100000
+
+// discardSink writes the events as server-sent events to nowhere, what the SSE response costs without a client.
+type discardSink struct{}
+
+func (discardSink) send(event string, data []byte) {
+	_ = writeEvent(io.Discard, 0, event, data)
+}
+
+// streamByByte is how the output was read before it was read in chunks: a byte at a time,
+// with an event per line and no batches.
+func streamByByte(ctx context.Context, r io.Reader, event string, s sink, wg *sync.WaitGroup, out *budget) {
+	defer wg.Done()
+
+	var (
+		buf  = make([]byte, 1)
+		line []byte
+	)
+	for {
+		n, e := r.Read(buf)
+		if ctx.Err() == nil {
+			for _, b := range out.take(event, buf[:n]) {
+				switch b {
+				case '\r', '\n':
+					send(line, event, s)
+					line = []byte{}
+				case '\x0c':
+					send(line, event, s)
+					s.send(clearEvent, nil)
+					line = []byte{}
+				default:
+					line = append(line, b)
+				}
+			}
+		}
+		if e != nil {
+			if ctx.Err() == nil {
+				send(line, event, s)
+			}
+			return
+		}
+	}
+}
+
+// pipe returns the read end of a pipe the data is written to, like the output of the program.
+func pipe(b *testing.B, data []byte) *os.File {
+	r, w, err := os.Pipe()
+	if err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+	return r
+}
+
+func benchOutput() []byte {
+	var b bytes.Buffer
+	for i := 0; i < benchLines; i++ {
+		b.WriteString("line " + strconv.Itoa(i) + "\n")
+	}
+	return b.Bytes()
+}
+
+// unlimited is a budget the output of the benchmarks is within.
+func unlimited() *budget {
+	return &budget{maxBytes: -1, maxLines: -1, pending: map[string]bool{}, exceed: func() {}}
+}
+
+// BenchmarkStream compares reading the output from a pipe a byte at a time and sending every line,
+// with reading it in chunks, splitting it and sending the lines in batches.
+//
+//	go test -run '^$' -bench Stream ./internal/handlers
+func BenchmarkStream(b *testing.B) {
+	data := benchOutput()
+	ctx := context.Background()
+
+	b.Run("bytes", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			var wg sync.WaitGroup
+			wg.Add(1)
+			r := pipe(b, data)
+			streamByByte(ctx, r, stdoutKey, discardSink{}, &wg, unlimited())
+			r.Close()
+		}
+		b.ReportMetric(float64(benchLines*b.N)/b.Elapsed().Seconds(), "lines/s")
+	})
+
+	b.Run("chunks", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			var wg sync.WaitGroup
+			wg.Add(1)
+			batch := newBatchSink(discardSink{})
+			r := pipe(b, data)
+			stream(ctx, r, stdoutKey, newOutput(stdoutKey, batch, false), &wg, unlimited())
+			batch.flush()
+			r.Close()
+		}
+		b.ReportMetric(float64(benchLines*b.N)/b.Elapsed().Seconds(), "lines/s")
+	})
+}
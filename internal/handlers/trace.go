@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/db"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/executor"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/tracing"
+)
+
+const (
+	traceEvent     = "trace"  // the timeline of the trace of a run, after the result event
+	traceKeyPrefix = "trace/" // of the traces in the snippet bucket
+)
+
+type traceTimeline struct {
+	*tracing.Timeline
+	URL   string `json:"url,omitempty"`   // of the raw trace, for go tool trace
+	Error string `json:"error,omitempty"` // why there is no timeline
+}
+
+// sendTrace stores the trace of a run with the snippets and sends its timeline as a trace event.
+// A program that exits without returning from main, or is killed, has none; a trace that cannot be read,
+// such as of a Go version the reader does not know, is still stored. One larger than config.ArtifactMaxSize only has an error.
+func sendTrace(ctx context.Context, artifacts map[string]executor.Artifact, s sink) {
+	a, ok := artifacts[executor.Trace]
+	if !ok {
+		s.send(traceEvent, []byte("{}"))
+		return
+	}
+	if a.TooLarge() {
+		log.Printf("the trace is too large: %d bytes", a.Size)
+		if b, err := json.Marshal(traceTimeline{Error: fmt.Sprintf("the trace is %d bytes, more than %d", a.Size, config.ArtifactMaxSize)}); err == nil {
+			s.send(traceEvent, b)
+		}
+		return
+	}
+	data := a.Data
+	// the trace is stored even if the client has gone, it can follow the run again
+	ctx = context.WithoutCancel(ctx)
+	var t traceTimeline
+	timeline, err := tracing.Summarize(data, config.TraceMaxSpans, config.TraceMaxBlocks)
+	if err != nil {
+		log.Printf("failed to read the trace: %s", err)
+		t.Error = err.Error()
+	}
+	t.Timeline = timeline
+
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+	if err = db.S3().PutObject(ctx, traceKeyPrefix+id, data); err != nil {
+		log.Printf("failed to store the trace: %s", err)
+	} else {
+		t.URL = "/trace/" + id
+	}
+	if b, err := json.Marshal(t); err == nil {
+		s.send(traceEvent, b)
+	}
+}
+
+// FetchTrace returns the trace of the id param of a run, for go tool trace.
+func FetchTrace(c *gin.Context) {
+	id := c.Param("id")
+	if !contentIDRe.MatchString(id) {
+		c.AbortWithStatusJSON(http.StatusNotFound, response{Error: "trace not found"})
+		return
+	}
+	data, err := db.S3().GetObject(c, traceKeyPrefix+id)
+	if err != nil {
+		if errors.Is(err, db.ErrObjectNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, response{Error: "trace not found"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, response{Error: err.Error()})
+		return
+	}
+	// the ID is the hash of the content, it never changes
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("Content-Disposition", `attachment; filename="`+id+`.trace"`)
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
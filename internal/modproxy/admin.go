@@ -0,0 +1,84 @@
+package modproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const usage = `usage:
+  modproxy add <module>[@version]...     allow modules, along with everything they require
+  modproxy add-dir <dir>@<version>...    allow the modules in local directories, which require nothing
+  modproxy remove <module>[@version]...  disallow a version, or the whole module
+  modproxy list                          print the allowed modules`
+
+// Admin runs an admin command against the store, the output is written to w.
+func Admin(store *Store, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch cmd, rest := args[0], args[1:]; cmd {
+	case "add":
+		if len(rest) == 0 {
+			return errors.New(usage)
+		}
+		for _, arg := range rest {
+			modPath, version, _ := strings.Cut(arg, "@")
+			if version == "" {
+				version = "latest"
+			}
+			added, err := store.Add(modPath, version)
+			for _, mv := range added {
+				fmt.Fprintf(w, "added %s\n", mv)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case "add-dir":
+		if len(rest) == 0 {
+			return errors.New(usage)
+		}
+		for _, arg := range rest {
+			dir, version, ok := strings.Cut(arg, "@")
+			if !ok {
+				return errors.New(usage)
+			}
+			mv, err := store.AddDir(dir, version)
+			if err != nil {
+				return fmt.Errorf("%s: %w", arg, err)
+			}
+			fmt.Fprintf(w, "added %s\n", mv)
+		}
+	case "remove":
+		if len(rest) == 0 {
+			return errors.New(usage)
+		}
+		for _, arg := range rest {
+			modPath, version, _ := strings.Cut(arg, "@")
+			if err := store.Remove(modPath, version); err != nil {
+				return fmt.Errorf("%s: %w", arg, err)
+			}
+			fmt.Fprintf(w, "removed %s\n", arg)
+		}
+	case "list":
+		mods, err := store.Modules()
+		if err != nil {
+			return err
+		}
+		paths := make([]string, 0, len(mods))
+		for p := range mods {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			fmt.Fprintf(w, "%s %s\n", p, strings.Join(mods[p], " "))
+		}
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package modproxy
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// Server implements the GOPROXY protocol on top of the store, see https://go.dev/ref/mod#goproxy-protocol
+type Server struct {
+	store *Store
+}
+
+func NewServer(store *Store) *Server {
+	return &Server{store: store}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+
+	// $module/@latest
+	if escaped, ok := strings.CutSuffix(path, "/@latest"); ok {
+		modPath, err := module.UnescapePath(escaped)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		version, err := s.store.latest(modPath)
+		if err != nil {
+			// 404 and 410 are the "not found" of the protocol
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.serveFile(w, r, modPath, mustEscapeVersion(version)+".info")
+		return
+	}
+
+	// $module/@v/list and $module/@v/$version.{info,mod,zip}
+	escaped, name, ok := strings.Cut(path, "/"+versionDir+"/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	modPath, err := module.UnescapePath(escaped)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if name != listFile {
+		version, ext, found := cutExt(name)
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		// reject anything that does not round trip, such as paths or non-canonical versions
+		v, e := module.UnescapeVersion(version)
+		if e != nil || module.Check(modPath, v) != nil {
+			http.Error(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+		name = mustEscapeVersion(v) + ext
+	}
+	s.serveFile(w, r, modPath, name)
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, modPath, name string) {
+	file, err := s.store.file(modPath, name)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrNotAllowed) {
+			status = http.StatusGone
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	f, err := os.Open(file)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(name, ".info"):
+		w.Header().Set("Content-Type", "application/json")
+	case strings.HasSuffix(name, ".zip"):
+		w.Header().Set("Content-Type", "application/zip")
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	http.ServeContent(w, r, "", stat.ModTime(), f)
+}
+
+func cutExt(name string) (string, string, bool) {
+	for _, ext := range []string{".info", ".mod", ".zip"} {
+		if v, ok := strings.CutSuffix(name, ext); ok {
+			return v, ext, true
+		}
+	}
+	return "", "", false
+}
+
+// mustEscapeVersion is only called with versions that passed module.Check
+func mustEscapeVersion(v string) string {
+	escaped, err := module.EscapeVersion(v)
+	if err != nil {
+		panic(err)
+	}
+	return escaped
+}
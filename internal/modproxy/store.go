@@ -0,0 +1,412 @@
+package modproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	modzip "golang.org/x/mod/zip"
+)
+
+const (
+	listFile   = "list"
+	versionDir = "@v"
+)
+
+var ErrNotAllowed = errors.New("module is not in the allow-list")
+
+// Store keeps the allowed modules on disk in the layout of the GOPROXY protocol,
+// so that serving them is a matter of reading files. A module is allowed iff it is in the store.
+type Store struct {
+	root string
+	mu   sync.RWMutex
+}
+
+func NewStore(root string) *Store {
+	return &Store{root: root}
+}
+
+// Modules returns the allowed module paths with their versions.
+func (s *Store) Modules() (map[string][]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make(map[string][]string)
+	err := filepath.WalkDir(s.root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if !d.IsDir() || d.Name() != versionDir {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		modPath, err := module.UnescapePath(filepath.ToSlash(rel))
+		if err != nil {
+			return nil
+		}
+		versions, err := s.versions(modPath)
+		if err != nil {
+			return err
+		}
+		if len(versions) > 0 {
+			out[modPath] = versions
+		}
+		return filepath.SkipDir
+	})
+	return out, err
+}
+
+// NoSumDB returns the GONOSUMDB patterns of the allowed modules, the checksum database is not reachable from the sandbox.
+func (s *Store) NoSumDB() string {
+	mods, err := s.Modules()
+	if err != nil {
+		return ""
+	}
+	paths := make([]string, 0, len(mods))
+	for p := range mods {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, ",")
+}
+
+// Add downloads the module at the version, "latest" is resolved, along with everything it requires.
+// It uses the go command with the network of the caller, so it is meant for the admin commands only.
+func (s *Store) Add(modPath, version string) ([]module.Version, error) {
+	tmp, err := os.MkdirTemp("", "modproxy-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	var (
+		added []module.Version
+		queue = []module.Version{{Path: modPath, Version: version}}
+		seen  = make(map[module.Version]bool)
+	)
+	for len(queue) > 0 {
+		mv := queue[0]
+		queue = queue[1:]
+		if seen[mv] {
+			continue
+		}
+		seen[mv] = true
+
+		info, err := download(tmp, mv)
+		if err != nil {
+			return added, err
+		}
+		mv.Version = info.Version
+		if !s.has(mv) {
+			if err = s.put(mv, info); err != nil {
+				return added, err
+			}
+			added = append(added, mv)
+		}
+
+		requires, err := requirements(info.GoMod)
+		if err != nil {
+			return added, err
+		}
+		queue = append(queue, requires...)
+	}
+	return added, nil
+}
+
+// AddDir adds the module in dir at the version, for modules that are not published, e.g. the display module.
+// The module has to require nothing, it is not resolved.
+func (s *Store) AddDir(dir, version string) (module.Version, error) {
+	goMod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return module.Version{}, err
+	}
+	f, err := modfile.ParseLax("go.mod", goMod, nil)
+	if err != nil {
+		return module.Version{}, err
+	}
+	if f.Module == nil {
+		return module.Version{}, fmt.Errorf("%s: no module path in go.mod", dir)
+	}
+	if len(f.Require) > 0 {
+		return module.Version{}, fmt.Errorf("%s: requirements are not supported", dir)
+	}
+	mv := module.Version{Path: f.Module.Mod.Path, Version: version}
+	if err = module.Check(mv.Path, mv.Version); err != nil {
+		return mv, err
+	}
+
+	tmp, err := os.MkdirTemp("", "modproxy-")
+	if err != nil {
+		return mv, err
+	}
+	defer os.RemoveAll(tmp)
+
+	info := &downloadInfo{
+		Info:  filepath.Join(tmp, "info"),
+		GoMod: filepath.Join(tmp, "mod"),
+		Zip:   filepath.Join(tmp, "zip"),
+	}
+	infoJSON, err := json.Marshal(map[string]any{"Version": version, "Time": time.Now().UTC()})
+	if err != nil {
+		return mv, err
+	}
+	if err = os.WriteFile(info.Info, infoJSON, 0644); err != nil {
+		return mv, err
+	}
+	if err = os.WriteFile(info.GoMod, goMod, 0644); err != nil {
+		return mv, err
+	}
+	z, err := os.Create(info.Zip)
+	if err != nil {
+		return mv, err
+	}
+	if err = modzip.CreateFromDir(z, mv, dir); err != nil {
+		z.Close()
+		return mv, err
+	}
+	if err = z.Close(); err != nil {
+		return mv, err
+	}
+	return mv, s.put(mv, info)
+}
+
+// Remove deletes a version of the module, or the whole module if version is empty.
+func (s *Store) Remove(modPath, version string) error {
+	dir, err := s.dir(modPath)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err = os.Stat(dir); err != nil {
+		return ErrNotAllowed
+	}
+	if version == "" {
+		return os.RemoveAll(dir)
+	}
+	ver, err := module.EscapeVersion(version)
+	if err != nil {
+		return err
+	}
+	for _, ext := range []string{".info", ".mod", ".zip"} {
+		if err = os.Remove(filepath.Join(dir, ver+ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return s.writeList(modPath)
+}
+
+// file returns the path of a file under the @v directory of an allowed module.
+func (s *Store) file(modPath, name string) (string, error) {
+	dir, err := s.dir(modPath)
+	if err != nil {
+		return "", err
+	}
+	if _, err = os.Stat(filepath.Join(dir, listFile)); err != nil {
+		return "", ErrNotAllowed
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// latest returns the highest release version, or the highest pre-release if there is none.
+func (s *Store) latest(modPath string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	versions, err := s.versions(modPath)
+	if err != nil || len(versions) == 0 {
+		return "", ErrNotAllowed
+	}
+	best := versions[0]
+	for _, v := range versions[1:] {
+		if (semver.Prerelease(best) != "" && semver.Prerelease(v) == "") ||
+			(semver.Prerelease(best) == "") == (semver.Prerelease(v) == "") && semver.Compare(v, best) > 0 {
+			best = v
+		}
+	}
+	return best, nil
+}
+
+func (s *Store) dir(modPath string) (string, error) {
+	if err := module.CheckPath(modPath); err != nil {
+		return "", err
+	}
+	escaped, err := module.EscapePath(modPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(escaped), versionDir), nil
+}
+
+func (s *Store) has(mv module.Version) bool {
+	dir, err := s.dir(mv.Path)
+	if err != nil {
+		return false
+	}
+	ver, err := module.EscapeVersion(mv.Version)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(dir, ver+".zip"))
+	return err == nil
+}
+
+func (s *Store) put(mv module.Version, info *downloadInfo) error {
+	dir, err := s.dir(mv.Path)
+	if err != nil {
+		return err
+	}
+	ver, err := module.EscapeVersion(mv.Version)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for src, ext := range map[string]string{info.Info: ".info", info.GoMod: ".mod", info.Zip: ".zip"} {
+		if err = copyFile(src, filepath.Join(dir, ver+ext)); err != nil {
+			return err
+		}
+	}
+	return s.writeList(mv.Path)
+}
+
+// versions must be called with the lock held.
+func (s *Store) versions(modPath string) ([]string, error) {
+	dir, err := s.dir(modPath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var versions []string
+	for _, e := range entries {
+		if name, ok := strings.CutSuffix(e.Name(), ".zip"); ok {
+			if v, err := module.UnescapeVersion(name); err == nil {
+				versions = append(versions, v)
+			}
+		}
+	}
+	semver.Sort(versions)
+	return versions, nil
+}
+
+// writeList must be called with the write lock held.
+func (s *Store) writeList(modPath string) error {
+	dir, err := s.dir(modPath)
+	if err != nil {
+		return err
+	}
+	versions, err := s.versions(modPath)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return os.RemoveAll(dir)
+	}
+	return os.WriteFile(filepath.Join(dir, listFile), []byte(strings.Join(versions, "\n")+"\n"), 0644)
+}
+
+// downloadInfo is the output of go mod download -json
+type downloadInfo struct {
+	Path    string
+	Version string
+	Info    string
+	GoMod   string
+	Zip     string
+	Error   string
+}
+
+func download(modCache string, mv module.Version) (*downloadInfo, error) {
+	cmd := exec.Command("go", "mod", "download", "-json", mv.Path+"@"+mv.Version)
+	cmd.Env = append(os.Environ(), "GOMODCACHE="+modCache, "GOFLAGS=-modcacherw")
+	out, err := cmd.Output()
+
+	var info downloadInfo
+	if e := json.Unmarshal(out, &info); e != nil {
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", mv, err)
+		}
+		return nil, e
+	}
+	if info.Error != "" {
+		return nil, fmt.Errorf("failed to download %s: %s", mv, info.Error)
+	}
+	return &info, nil
+}
+
+func requirements(goMod string) ([]module.Version, error) {
+	data, err := os.ReadFile(goMod)
+	if err != nil {
+		return nil, err
+	}
+	f, err := modfile.ParseLax(goMod, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]module.Version, 0, len(f.Require))
+	for _, r := range f.Require {
+		out = append(out, r.Mod)
+	}
+	return out, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+var (
+	defaultStore     *Store
+	defaultStoreOnce sync.Once
+)
+
+// Default returns the store at the configured path.
+func Default() *Store {
+	defaultStoreOnce.Do(func() {
+		defaultStore = NewStore(config.ModProxyPath)
+	})
+	return defaultStore
+}
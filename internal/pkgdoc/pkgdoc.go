@@ -0,0 +1,301 @@
+package pkgdoc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/doc"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrSymbolNotFound = errors.New("symbol not found")
+
+	// parsed packages never change for a given toolchain, keyed by GOROOT and import path
+	cache sync.Map
+)
+
+// Package is the rendered documentation of a package.
+type Package struct {
+	Name       string    `json:"name"`
+	ImportPath string    `json:"import_path"`
+	Synopsis   string    `json:"synopsis"`
+	Doc        string    `json:"doc,omitempty"`
+	Deprecated string    `json:"deprecated,omitempty"`
+	Consts     []Value   `json:"consts,omitempty"`
+	Vars       []Value   `json:"vars,omitempty"`
+	Funcs      []Func    `json:"funcs,omitempty"`
+	Types      []Type    `json:"types,omitempty"`
+	Examples   []Example `json:"examples,omitempty"`
+}
+
+// Value is a group of constants or variables.
+type Value struct {
+	Names      []string `json:"names"`
+	Decl       string   `json:"decl"`
+	Doc        string   `json:"doc,omitempty"`
+	Deprecated string   `json:"deprecated,omitempty"`
+}
+
+// Func is a function or a method.
+type Func struct {
+	Name       string    `json:"name"`
+	Recv       string    `json:"recv,omitempty"`
+	Decl       string    `json:"decl"`
+	Doc        string    `json:"doc,omitempty"`
+	Deprecated string    `json:"deprecated,omitempty"`
+	Examples   []Example `json:"examples,omitempty"`
+}
+
+// Type is a type declaration with its associated declarations.
+type Type struct {
+	Name       string    `json:"name"`
+	Decl       string    `json:"decl"`
+	Doc        string    `json:"doc,omitempty"`
+	Deprecated string    `json:"deprecated,omitempty"`
+	Consts     []Value   `json:"consts,omitempty"`
+	Vars       []Value   `json:"vars,omitempty"`
+	Funcs      []Func    `json:"funcs,omitempty"`
+	Methods    []Func    `json:"methods,omitempty"`
+	Examples   []Example `json:"examples,omitempty"`
+}
+
+// Example is an Example function from the test files of the package.
+// Play is a complete program that can be loaded into the editor, it is empty if the example is not runnable.
+type Example struct {
+	Name      string `json:"name"`
+	Doc       string `json:"doc,omitempty"`
+	Code      string `json:"code"`
+	Output    string `json:"output,omitempty"`
+	Unordered bool   `json:"unordered,omitempty"`
+	Play      string `json:"play,omitempty"`
+}
+
+// Load parses the package of the given import path under goRoot.
+func Load(goRoot, importPath string) (*Package, error) {
+	key := goRoot + "\x00" + importPath
+	if p, ok := cache.Load(key); ok {
+		return p.(*Package), nil
+	}
+
+	ctx := build.Default
+	ctx.GOROOT = goRoot
+	bp, err := ctx.ImportDir(filepath.Join(goRoot, "src", importPath), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		fset  = token.NewFileSet()
+		files []*ast.File
+	)
+	for _, names := range [][]string{bp.GoFiles, bp.TestGoFiles, bp.XTestGoFiles} {
+		for _, name := range names {
+			f, e := parser.ParseFile(fset, filepath.Join(bp.Dir, name), nil, parser.ParseComments)
+			if e != nil {
+				return nil, e
+			}
+			files = append(files, f)
+		}
+	}
+
+	dp, err := doc.NewFromFiles(fset, files, importPath)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newPackage(fset, dp)
+	cache.Store(key, p)
+	return p, nil
+}
+
+// Symbol returns a copy of the package that only contains the given symbol,
+// which is either a top-level name like "Get" or a method like "Client.Do".
+func (p *Package) Symbol(symbol string) (*Package, error) {
+	out := &Package{
+		Name:       p.Name,
+		ImportPath: p.ImportPath,
+		Synopsis:   p.Synopsis,
+		Deprecated: p.Deprecated,
+	}
+
+	typeName, method, isMethod := strings.Cut(symbol, ".")
+	for _, t := range p.Types {
+		if t.Name != typeName {
+			continue
+		}
+		if !isMethod {
+			out.Types = []Type{t}
+			return out, nil
+		}
+		for _, m := range t.Methods {
+			if m.Name == method {
+				out.Types = []Type{{Name: t.Name, Decl: t.Decl, Deprecated: t.Deprecated, Methods: []Func{m}}}
+				return out, nil
+			}
+		}
+		return nil, ErrSymbolNotFound
+	}
+	if isMethod {
+		return nil, ErrSymbolNotFound
+	}
+
+	for _, f := range p.Funcs {
+		if f.Name == symbol {
+			out.Funcs = []Func{f}
+			return out, nil
+		}
+	}
+	// a constructor is listed under its type
+	for _, t := range p.Types {
+		for _, f := range t.Funcs {
+			if f.Name == symbol {
+				out.Funcs = []Func{f}
+				return out, nil
+			}
+		}
+	}
+	if v, ok := findValue(p.Consts, symbol); ok {
+		out.Consts = []Value{v}
+		return out, nil
+	}
+	if v, ok := findValue(p.Vars, symbol); ok {
+		out.Vars = []Value{v}
+		return out, nil
+	}
+	// typed constants and variables are listed under their type too
+	for _, t := range p.Types {
+		if v, ok := findValue(t.Consts, symbol); ok {
+			out.Consts = []Value{v}
+			return out, nil
+		}
+		if v, ok := findValue(t.Vars, symbol); ok {
+			out.Vars = []Value{v}
+			return out, nil
+		}
+	}
+	return nil, ErrSymbolNotFound
+}
+
+func findValue(values []Value, name string) (Value, bool) {
+	for _, v := range values {
+		for _, n := range v.Names {
+			if n == name {
+				return v, true
+			}
+		}
+	}
+	return Value{}, false
+}
+
+func newPackage(fset *token.FileSet, dp *doc.Package) *Package {
+	p := &Package{
+		Name:       dp.Name,
+		ImportPath: dp.ImportPath,
+		Synopsis:   dp.Synopsis(dp.Doc),
+		Doc:        dp.Doc,
+		Deprecated: deprecation(dp.Doc),
+		Consts:     newValues(fset, dp.Consts),
+		Vars:       newValues(fset, dp.Vars),
+		Funcs:      newFuncs(fset, dp.Funcs),
+		Examples:   newExamples(fset, dp.Examples),
+	}
+	for _, t := range dp.Types {
+		p.Types = append(p.Types, Type{
+			Name:       t.Name,
+			Decl:       render(fset, t.Decl),
+			Doc:        t.Doc,
+			Deprecated: deprecation(t.Doc),
+			Consts:     newValues(fset, t.Consts),
+			Vars:       newValues(fset, t.Vars),
+			Funcs:      newFuncs(fset, t.Funcs),
+			Methods:    newFuncs(fset, t.Methods),
+			Examples:   newExamples(fset, t.Examples),
+		})
+	}
+	return p
+}
+
+func newValues(fset *token.FileSet, values []*doc.Value) []Value {
+	var out []Value
+	for _, v := range values {
+		out = append(out, Value{
+			Names:      v.Names,
+			Decl:       render(fset, v.Decl),
+			Doc:        v.Doc,
+			Deprecated: deprecation(v.Doc),
+		})
+	}
+	return out
+}
+
+func newFuncs(fset *token.FileSet, funcs []*doc.Func) []Func {
+	var out []Func
+	for _, f := range funcs {
+		out = append(out, Func{
+			Name:       f.Name,
+			Recv:       f.Recv,
+			Decl:       render(fset, f.Decl),
+			Doc:        f.Doc,
+			Deprecated: deprecation(f.Doc),
+			Examples:   newExamples(fset, f.Examples),
+		})
+	}
+	return out
+}
+
+func newExamples(fset *token.FileSet, examples []*doc.Example) []Example {
+	var out []Example
+	for _, e := range examples {
+		ex := Example{
+			Name:      "Example" + e.Name,
+			Doc:       e.Doc,
+			Code:      render(fset, e.Code),
+			Output:    e.Output,
+			Unordered: e.Unordered,
+		}
+		if e.Play != nil {
+			ex.Play = render(fset, e.Play)
+		}
+		out = append(out, ex)
+	}
+	return out
+}
+
+// render prints a node as Go source, errors only happen on malformed AST and are rendered inline.
+func render(fset *token.FileSet, node any) string {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, node); err != nil {
+		return fmt.Sprintf("/* %s */", err)
+	}
+	// example bodies are printed with the surrounding braces
+	if b, ok := node.(*ast.BlockStmt); ok && b != nil {
+		return unindent(strings.TrimLeft(strings.TrimSuffix(strings.TrimPrefix(buf.String(), "{\n"), "\n}"), "\n"))
+	}
+	return buf.String()
+}
+
+func unindent(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, "\t")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// deprecation returns the "Deprecated: " paragraph of a doc comment, see https://go.dev/wiki/Deprecated
+func deprecation(text string) string {
+	for _, para := range strings.Split(text, "\n\n") {
+		if rest, ok := strings.CutPrefix(para, "Deprecated: "); ok {
+			return strings.TrimSpace(strings.ReplaceAll(rest, "\n", " "))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,177 @@
+// Package profiling summarizes the pprof profiles of the runs for the client: the functions that take the most,
+// and the call tree for a flame graph.
+package profiling
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// the sandbox runner renames main of the program and calls it from a main of its own, see sandbox/wrap.go
+const (
+	wrapperMain = "main.main"
+	userMain    = "main.sandboxUserMain"
+	// the functions of the profiler, its own samples are left out
+	profilerPrefix = "runtime/pprof."
+)
+
+// treeMinFraction of the total is the least a node of the tree takes, smaller ones are left out
+const treeMinFraction = 0.001
+
+// Summary of a profile, in the values of one of its sample types.
+type Summary struct {
+	Type  string `json:"type"` // of the samples, such as cpu or alloc_space
+	Unit  string `json:"unit"` // such as nanoseconds or bytes
+	Total int64  `json:"total"`
+	Top   []Func `json:"top"`  // by flat value
+	Tree  *Node  `json:"tree"` // the callers are the parents
+}
+
+// Func is a function of the profile.
+type Func struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int64  `json:"line,omitempty"` // where the function starts
+	Flat int64  `json:"flat"`           // in the function itself
+	Cum  int64  `json:"cum"`            // in the function and the ones it calls
+}
+
+// Node is a call path of the tree, the root is all of them.
+type Node struct {
+	Name     string  `json:"name"`
+	Value    int64   `json:"value"`
+	Children []*Node `json:"children,omitempty"` // by value, the largest first
+}
+
+// Summarize parses a profile in the gzipped protobuf format and summarizes the sample type, the last one if empty,
+// with the top functions up to n.
+func Summarize(data []byte, sampleType string, n int) (*Summary, error) {
+	p, err := profile.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	index := len(p.SampleType) - 1
+	if sampleType != "" {
+		if index, err = p.SampleIndexByName(sampleType); err != nil {
+			return nil, err
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("the profile has no samples")
+	}
+
+	s := &Summary{
+		Type: p.SampleType[index].Type,
+		Unit: p.SampleType[index].Unit,
+		Tree: &Node{Name: "root"},
+	}
+	funcs := map[string]*Func{}
+	for _, sample := range p.Sample {
+		v := sample.Value[index]
+		stack, ok := frames(sample)
+		if v == 0 || !ok {
+			continue
+		}
+		s.Total += v
+
+		node := s.Tree
+		node.Value += v
+		seen := map[string]bool{} // a recursive function counts once
+		for i, f := range stack {
+			node = node.child(f.Name)
+			node.Value += v
+
+			fn := funcs[f.Name]
+			if fn == nil {
+				fn = &Func{Name: f.Name, File: f.Filename, Line: f.StartLine}
+				// the code of the program is in a workspace of the server
+				if strings.HasPrefix(f.Name, "main.") {
+					fn.File = path.Base(f.Filename)
+				}
+				funcs[f.Name] = fn
+			}
+			if i == len(stack)-1 {
+				fn.Flat += v
+			}
+			if !seen[f.Name] {
+				fn.Cum += v
+				seen[f.Name] = true
+			}
+		}
+	}
+
+	for _, f := range funcs {
+		s.Top = append(s.Top, *f)
+	}
+	sort.Slice(s.Top, func(i, j int) bool {
+		if s.Top[i].Flat != s.Top[j].Flat {
+			return s.Top[i].Flat > s.Top[j].Flat
+		}
+		return s.Top[i].Cum > s.Top[j].Cum
+	})
+	s.Top = s.Top[:min(n, len(s.Top))]
+	s.Tree.prune(int64(float64(s.Total) * treeMinFraction))
+	return s, nil
+}
+
+// frames returns the functions of the stack of the sample from the outermost, the inlined ones included.
+// The wrapper of the sandbox runner is left out, and the samples of the profiler and the wrapper themselves are not ok.
+func frames(sample *profile.Sample) ([]profile.Function, bool) {
+	var stack []profile.Function
+	for i := len(sample.Location) - 1; i >= 0; i-- {
+		lines := sample.Location[i].Line
+		// the last line is the caller the others are inlined into
+		for j := len(lines) - 1; j >= 0; j-- {
+			if f := lines[j].Function; f != nil {
+				if strings.HasPrefix(f.Name, profilerPrefix) {
+					return nil, false
+				}
+				stack = append(stack, *f)
+			}
+		}
+	}
+	for i := 0; i < len(stack); i++ {
+		if stack[i].Name == wrapperMain {
+			if i+1 == len(stack) || stack[i+1].Name != userMain {
+				return nil, false
+			}
+			stack = append(stack[:i], stack[i+1:]...)
+		}
+		// the closures of main too
+		if rest, ok := strings.CutPrefix(stack[i].Name, userMain); ok && (rest == "" || rest[0] == '.') {
+			stack[i].Name = wrapperMain + rest
+		}
+	}
+	return stack, true
+}
+
+func (n *Node) child(name string) *Node {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &Node{Name: name}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// prune leaves out the descendants under least, and sorts the children.
+func (n *Node) prune(least int64) {
+	children := n.Children[:0]
+	for _, c := range n.Children {
+		if c.Value >= least {
+			c.prune(least)
+			children = append(children, c)
+		}
+	}
+	n.Children = children
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Value > n.Children[j].Value
+	})
+}
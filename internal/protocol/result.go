@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// ResultFd is the descriptor of the sandbox runner the result is written to, the first one after stderr.
+const ResultFd = 3
+
+// the stages a run can end in
+const (
+	StageSetup = "setup" // the sandbox could not be prepared
+	StageBuild = "build"
+	StageRun   = "run"
+)
+
+// the limits that end a run
+const (
+	TimeoutWall = "wall"
+	TimeoutCPU  = "cpu"
+)
+
+var ErrNoResult = errors.New("the sandbox runner did not report a result")
+
+// Result is the report of a run, the sandbox runner writes it as a single line of JSON.
+// The runner has its own copy of it, see sandbox/result.go, the two have to match.
+type Result struct {
+	Stage      string `json:"stage"`                // the stage the run ended in
+	ExitCode   int    `json:"exit_code"`            // of the build or the program, -1 if killed by a signal
+	Signal     string `json:"signal,omitempty"`     // that killed the program
+	Timeout    string `json:"timeout,omitempty"`    // the time limit the program exceeded, if any
+	OOMKilled  bool   `json:"oom_killed,omitempty"` // the program was killed for exceeding the memory limit
+	WallTime   int64  `json:"wall_time_us"`
+	CPUUser    int64  `json:"cpu_user_us"`
+	CPUSystem  int64  `json:"cpu_system_us"`
+	PeakMemory int64  `json:"peak_memory_bytes"`
+	Unisolated bool   `json:"unisolated,omitempty"` // the program ran without namespaces, SANDBOX_ALLOW_UNISOLATED of the runner
+	Truncated  bool   `json:"truncated,omitempty"`  // the server stopped sending the output, it is never set by the runner
+	Error      string `json:"error,omitempty"`      // why the setup failed
+}
+
+// OK reports whether the program ran and exited with 0.
+func (r *Result) OK() bool {
+	return r.Stage == StageRun && r.ExitCode == 0 && r.Signal == "" && r.Timeout == ""
+}
+
+// Compiled reports whether a build without a run, for a WebAssembly target, succeeded.
+func (r *Result) Compiled() bool {
+	return r.Stage == StageBuild && r.ExitCode == 0 && r.Signal == ""
+}
+
+// Read decodes the result from the result descriptor of the runner until it is closed.
+func Read(r io.Reader) (*Result, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, ErrNoResult
+	}
+	var res Result
+	if err = json.Unmarshal(b, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -0,0 +1,188 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrQueueFull = errors.New("the execution queue is full")
+
+// Scheduler caps the number of concurrent executions. The rest wait in a bounded queue,
+// which is served round-robin across clients so that one client cannot starve the others.
+type Scheduler struct {
+	maxRunning   int
+	maxQueued    int
+	maxPerClient int
+
+	mu      sync.Mutex
+	running int
+	queued  int
+	queues  map[string][]*waiter // FIFO per client
+	clients []string             // clients with waiters in round-robin order
+	next    int                  // index in clients served next
+}
+
+type waiter struct {
+	client   string
+	ready    chan struct{} // closed once admitted
+	pos      chan int      // the latest queue position
+	lastPos  int
+	admitted bool
+}
+
+func New(maxRunning, maxQueued, maxPerClient int) *Scheduler {
+	return &Scheduler{
+		maxRunning:   max(maxRunning, 1),
+		maxQueued:    maxQueued,
+		maxPerClient: maxPerClient,
+		queues:       make(map[string][]*waiter),
+	}
+}
+
+// Acquire blocks until the client may start an execution, and calls onQueued with the
+// 1-based queue position whenever it changes. The returned release must be called once the execution ends.
+func (s *Scheduler) Acquire(ctx context.Context, client string, onQueued func(pos int)) (func(), error) {
+	s.mu.Lock()
+	if s.running < s.maxRunning && s.queued == 0 {
+		s.running++
+		s.mu.Unlock()
+		return s.releaseFunc(), nil
+	}
+	if s.queued >= s.maxQueued || len(s.queues[client]) >= s.maxPerClient {
+		s.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+
+	w := &waiter{
+		client: client,
+		ready:  make(chan struct{}),
+		pos:    make(chan int, 1),
+	}
+	if len(s.queues[client]) == 0 {
+		s.clients = append(s.clients, client)
+	}
+	s.queues[client] = append(s.queues[client], w)
+	s.queued++
+	s.updatePositions()
+	s.mu.Unlock()
+
+	for {
+		select {
+		case <-w.ready:
+			return s.releaseFunc(), nil
+		case pos := <-w.pos:
+			onQueued(pos)
+		case <-ctx.Done():
+			s.mu.Lock()
+			if w.admitted {
+				// admitted at the same time, hand the slot over to the next one
+				s.running--
+				s.dispatch()
+			} else {
+				s.remove(w)
+				s.updatePositions()
+			}
+			s.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Stats returns the number of running and queued executions.
+func (s *Scheduler) Stats() (running, queued int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running, s.queued
+}
+
+func (s *Scheduler) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.running--
+			s.dispatch()
+		})
+	}
+}
+
+// the methods below must be called with s.mu held
+
+// dispatch admits waiters while there are free slots.
+func (s *Scheduler) dispatch() {
+	admitted := false
+	for s.running < s.maxRunning && s.queued > 0 {
+		s.next %= len(s.clients)
+		w := s.queues[s.clients[s.next]][0]
+		s.remove(w)
+		if len(s.queues[w.client]) > 0 {
+			// the client keeps its place in the rotation, move on to the next one
+			s.next++
+		}
+
+		w.admitted = true
+		close(w.ready)
+		s.running++
+		admitted = true
+	}
+	if admitted {
+		s.updatePositions()
+	}
+}
+
+func (s *Scheduler) remove(w *waiter) {
+	q := s.queues[w.client]
+	for i, other := range q {
+		if other == w {
+			q = append(q[:i], q[i+1:]...)
+			s.queued--
+			break
+		}
+	}
+	if len(q) > 0 {
+		s.queues[w.client] = q
+		return
+	}
+
+	delete(s.queues, w.client)
+	for i, c := range s.clients {
+		if c == w.client {
+			s.clients = append(s.clients[:i], s.clients[i+1:]...)
+			if i < s.next {
+				s.next--
+			}
+			break
+		}
+	}
+}
+
+// updatePositions tells every waiter its place in the round-robin order.
+func (s *Scheduler) updatePositions() {
+	pos := 1
+	for round := 0; ; round++ {
+		found := false
+		for i := range s.clients {
+			q := s.queues[s.clients[(s.next+i)%len(s.clients)]]
+			if round >= len(q) {
+				continue
+			}
+			found = true
+
+			if w := q[round]; w.lastPos != pos {
+				// only the latest position matters
+				select {
+				case <-w.pos:
+				default:
+				}
+				w.pos <- pos
+				w.lastPos = pos
+			}
+			pos++
+		}
+		if !found {
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package toolchain
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
+)
+
+var (
+	ErrUnknownVersion = errors.New("unsupported go version")
+	ErrOutsideRoots   = errors.New("path is outside of GOROOT and the module cache")
+
+	modCache     string
+	modCacheOnce sync.Once
+
+	installedVersions sync.Map // version -> bool, see installed
+)
+
+// GoRoot returns the GOROOT of the given version, an empty version means the default one.
+// A version whose toolchain is missing or of another version is unknown.
+func GoRoot(version string) (string, error) {
+	if version == "" {
+		version = config.DefaultGoVersion
+	}
+	root, ok := config.GoRoots[version]
+	if !ok || !installed(version, root) {
+		return "", ErrUnknownVersion
+	}
+	return root, nil
+}
+
+// installed reports whether the toolchain in root is of the version, go1.24.3 is of 1, 1.24 and 1.24.3.
+// It is checked once per version.
+func installed(version, root string) bool {
+	if ok, found := installedVersions.Load(version); found {
+		return ok.(bool)
+	}
+	// the first line is the version of the toolchain, e.g. go1.24.3
+	b, err := os.ReadFile(filepath.Join(root, "VERSION"))
+	line, _, _ := strings.Cut(string(b), "\n")
+	have := strings.TrimPrefix(line, "go")
+	ok := err == nil && (have == version || strings.HasPrefix(have, version+"."))
+	if !ok {
+		log.Printf("Go %s is not installed in %s, it is not offered", version, root)
+	}
+	installedVersions.Store(version, ok)
+	return ok
+}
+
+// ModCache returns the module cache used by the sandbox builds.
+func ModCache() string {
+	modCacheOnce.Do(func() {
+		if modCache = os.Getenv(config.GoModCacheKey); modCache != "" {
+			return
+		}
+		if out, err := exec.Command("go", "env", "GOMODCACHE").Output(); err == nil {
+			modCache = strings.TrimSpace(string(out))
+		}
+	})
+	return modCache
+}
+
+// ResolveSource maps a source file path reported by gopls to a file of the given Go version.
+// Paths in any known GOROOT are rebased onto the GOROOT of the version, paths in the module cache are kept.
+// The result is guaranteed to be inside one of them after symlinks are resolved.
+func ResolveSource(path, version string) (string, error) {
+	goRoot, err := GoRoot(version)
+	if err != nil {
+		return "", err
+	}
+	path = filepath.Clean(path)
+
+	var (
+		srcRoot = filepath.Join(goRoot, "src")
+		target  string
+	)
+	for _, root := range config.GoRoots {
+		if rel, ok := within(filepath.Join(root, "src"), path); ok {
+			target = filepath.Join(srcRoot, rel)
+			break
+		}
+	}
+	cache := ModCache()
+	if target == "" && cache != "" {
+		if _, ok := within(cache, path); ok {
+			target = path
+		}
+	}
+	if target == "" {
+		return "", ErrOutsideRoots
+	}
+
+	// a symlink must not lead out of the allowed roots
+	resolved, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range []string{srcRoot, cache} {
+		if root == "" {
+			continue
+		}
+		if r, e := filepath.EvalSymlinks(root); e == nil {
+			if _, ok := within(r, resolved); ok {
+				return resolved, nil
+			}
+		}
+	}
+	return "", ErrOutsideRoots
+}
+
+// within reports whether the path is inside root, and returns the relative path if so.
+func within(root, path string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return rel, true
+}
@@ -0,0 +1,235 @@
+// Package tracing summarizes the execution traces of the runs for the client: what state each goroutine was in
+// and when, where they blocked, and the GC pauses, to be drawn as a timeline.
+package tracing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/trace"
+)
+
+// the sandbox runner renames main of the program and calls it from a main of its own, see sandbox/wrap.go
+const (
+	wrapperMain = "main.main"
+	userMain    = "main.sandboxUserMain"
+)
+
+// stackDepth is the most frames of a blocking event kept, from the innermost
+const stackDepth = 8
+
+// Timeline of a trace, the times are in nanoseconds from its start.
+type Timeline struct {
+	Duration   int64        `json:"duration"`
+	Goroutines []*Goroutine `json:"goroutines"` // of the program, not the ones of the runtime
+	Blocking   []Block      `json:"blocking"`
+	GCPauses   []Range      `json:"gc_pauses"` // the world is stopped for the GC
+	GCCycles   []Range      `json:"gc_cycles"` // the concurrent marking
+	Truncated  bool         `json:"truncated"` // there were more spans or blocking events than kept
+}
+
+// Goroutine of the program and the states it was in.
+type Goroutine struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"` // the function it runs
+	Spans []Span `json:"spans"`
+}
+
+// Span is a time a goroutine was in a state: running, runnable, waiting or syscall.
+type Span struct {
+	State  string `json:"state"`
+	Start  int64  `json:"start"`
+	End    int64  `json:"end"`
+	Reason string `json:"reason,omitempty"` // of waiting, such as chan receive, select, sync or sleep
+}
+
+// Block is a time a goroutine was waiting, and where.
+type Block struct {
+	Goroutine int64   `json:"goroutine"`
+	Start     int64   `json:"start"`
+	End       int64   `json:"end"`
+	Reason    string  `json:"reason"`
+	Stack     []Frame `json:"stack,omitempty"` // the innermost first
+}
+
+type Frame struct {
+	Func string `json:"func"`
+	File string `json:"file"`
+	Line uint64 `json:"line"`
+}
+
+type Range struct {
+	Name  string `json:"name"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+}
+
+// goroutine is the state of a goroutine while the trace is read.
+type goroutine struct {
+	*Goroutine
+	state  trace.GoState
+	since  int64
+	reason string
+	stack  []Frame // where it is waiting
+}
+
+// Summarize reads a trace of runtime/trace, keeping up to maxSpans spans and maxBlocks blocking events.
+func Summarize(data []byte, maxSpans, maxBlocks int) (*Timeline, error) {
+	r, err := trace.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		t          = &Timeline{}
+		goroutines = map[trace.GoID]*goroutine{}
+		ranges     = map[string]int64{} // the start of the GC ranges in progress
+		start      trace.Time
+		spans      int
+	)
+	for {
+		ev, err := r.ReadEvent()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start == 0 {
+			start = ev.Time()
+		}
+		now := int64(ev.Time().Sub(start))
+		t.Duration = now
+
+		switch ev.Kind() {
+		case trace.EventStateTransition:
+			st := ev.StateTransition()
+			if st.Resource.Kind != trace.ResourceGoroutine {
+				continue
+			}
+			id := st.Resource.Goroutine()
+			from, to := st.Goroutine()
+			g := goroutines[id]
+			if g == nil {
+				g = &goroutine{Goroutine: &Goroutine{ID: int64(id)}, state: from, since: now}
+				goroutines[id] = g
+			}
+			if g.Name == "" {
+				// the start of a new goroutine, or where the goroutine is
+				if f := frames(st.Stack, 0); len(f) > 0 {
+					g.Name = f[len(f)-1].Func
+				} else if ev.Goroutine() == id {
+					if f := frames(ev.Stack(), 0); len(f) > 0 {
+						g.Name = f[len(f)-1].Func
+					}
+				}
+			}
+
+			if state := stateName(g.state); state != "" && now > g.since {
+				if spans < maxSpans {
+					g.Spans = append(g.Spans, Span{State: state, Start: g.since, End: now, Reason: g.reason})
+					spans++
+				} else {
+					t.Truncated = true
+				}
+				if g.state == trace.GoWaiting && g.reason != "" {
+					if len(t.Blocking) < maxBlocks {
+						t.Blocking = append(t.Blocking, Block{Goroutine: g.ID, Start: g.since, End: now, Reason: g.reason, Stack: g.stack})
+					} else {
+						t.Truncated = true
+					}
+				}
+			}
+			g.state, g.since, g.reason, g.stack = to, now, "", nil
+			if to == trace.GoWaiting {
+				g.reason, g.stack = st.Reason, frames(st.Stack, stackDepth)
+			}
+
+		case trace.EventRangeBegin:
+			ranges[ev.Range().Name] = now
+		case trace.EventRangeEnd:
+			name := ev.Range().Name
+			since, ok := ranges[name]
+			if !ok {
+				continue
+			}
+			delete(ranges, name)
+			switch {
+			case strings.HasPrefix(name, "stop-the-world (GC"):
+				t.GCPauses = append(t.GCPauses, Range{Name: name, Start: since, End: now})
+			case strings.HasPrefix(name, "GC concurrent mark"):
+				t.GCCycles = append(t.GCCycles, Range{Name: name, Start: since, End: now})
+			}
+		}
+	}
+
+	// what is still going on lasts until the end
+	for _, g := range goroutines {
+		if state := stateName(g.state); state != "" && t.Duration > g.since && spans < maxSpans {
+			g.Spans = append(g.Spans, Span{State: state, Start: g.since, End: t.Duration, Reason: g.reason})
+			spans++
+		}
+		if g.Name != "" && !runtimeFunc(g.Name) {
+			t.Goroutines = append(t.Goroutines, g.Goroutine)
+		}
+	}
+	sort.Slice(t.Goroutines, func(i, j int) bool {
+		return t.Goroutines[i].ID < t.Goroutines[j].ID
+	})
+	// only the blocking of the goroutines of the program
+	program := map[int64]bool{}
+	for _, g := range t.Goroutines {
+		program[g.ID] = true
+	}
+	blocking := t.Blocking[:0]
+	for _, b := range t.Blocking {
+		if program[b.Goroutine] {
+			blocking = append(blocking, b)
+		}
+	}
+	t.Blocking = blocking
+	return t, nil
+}
+
+// stateName returns the name of a state a goroutine is shown in, empty if it does not exist.
+func stateName(s trace.GoState) string {
+	switch s {
+	case trace.GoRunning, trace.GoRunnable, trace.GoWaiting, trace.GoSyscall:
+		return strings.ToLower(s.String())
+	default:
+		return ""
+	}
+}
+
+// runtimeFunc reports whether the goroutine of the function is one of the runtime, or of the tracer.
+func runtimeFunc(name string) bool {
+	return name != "runtime.main" && (strings.HasPrefix(name, "runtime.") || strings.HasPrefix(name, "runtime/trace."))
+}
+
+// frames returns the frames of the stack from the innermost, up to depth if it is not 0.
+// The wrapper of the sandbox runner is left out.
+func frames(stack trace.Stack, depth int) []Frame {
+	var f []Frame
+	stack.Frames(func(sf trace.StackFrame) bool {
+		if sf.Func == wrapperMain && len(f) > 0 && f[len(f)-1].Func == wrapperMain {
+			return true
+		}
+		name := sf.Func
+		// the closures of main too
+		if rest, ok := strings.CutPrefix(name, userMain); ok && (rest == "" || rest[0] == '.') {
+			name = wrapperMain + rest
+		}
+		file := sf.File
+		// the code of the program is in a workspace of the server
+		if strings.HasPrefix(name, "main.") {
+			file = path.Base(file)
+		}
+		f = append(f, Frame{Func: name, File: file, Line: sf.Line})
+		return depth == 0 || len(f) < depth
+	})
+	return f
+}
@@ -0,0 +1,151 @@
+// Package wasm runs programs built for wasip1 in a WebAssembly runtime embedded in the server.
+// The program has no filesystem and no network, and its memory, function calls and wall time are limited,
+// so it is isolated without seccomp or namespaces.
+package wasm
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/protocol"
+)
+
+const (
+	pageSize = 64 * 1024 // of the memory of a module
+	maxPages = 65536     // of a 32-bit memory, 4 GB
+)
+
+// the compiled code of the modules, the same program is only compiled once
+var cache = wazero.NewCompilationCache()
+
+// errOutOfFuel stops a program that makes more calls than Limits.Calls.
+var errOutOfFuel = errors.New("out of fuel")
+
+// Limits of a run, a program is stopped once it has made Calls function calls or run for Time.
+// wazero counts no instructions, so the calls are the fuel: a loop that calls nothing only ends with Time.
+type Limits struct {
+	Memory uint64 // bytes, rounded down to whole pages, 1 to 65536 of them
+	Calls  uint64
+	Time   time.Duration
+}
+
+// fuel counts down the function calls of a program, it is in the context of the call of the program.
+type fuel uint64
+
+type fuelKey struct{}
+
+// fuelListeners count the calls of every function. The listeners are kept with the compiled code in the cache,
+// so the fuel of a run is found in the context. A call without fuel panics, wazero returns the error from the call.
+type fuelListeners struct{}
+
+func (fuelListeners) NewFunctionListener(api.FunctionDefinition) experimental.FunctionListener {
+	return experimental.FunctionListenerFunc(func(ctx context.Context, _ api.Module, _ api.FunctionDefinition, _ []uint64, _ experimental.StackIterator) {
+		// the program runs on a single goroutine
+		f, ok := ctx.Value(fuelKey{}).(*fuel)
+		if !ok {
+			return
+		}
+		if *f == 0 {
+			panic(errOutOfFuel)
+		}
+		*f--
+	})
+}
+
+// Run runs the module until it exits, ctx is done or it exceeds the limits. Its output goes to stdout and stderr.
+// env is the environment of the program. How it ended is reported like the sandbox runner does.
+func Run(ctx context.Context, module []byte, env []string, stdout, stderr io.Writer, limits Limits) *protocol.Result {
+	pages := limits.Memory / pageSize
+	if pages < 1 || pages > maxPages {
+		return setupFailed(fmt.Errorf("the memory limit is %d pages of %d bytes, not 1 to %d", pages, pageSize, maxPages))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, limits.Time)
+	defer cancel()
+	// the listeners are set when the module is compiled
+	ctx = experimental.WithFunctionListenerFactory(ctx, fuelListeners{})
+
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(uint32(pages)).
+		WithCompilationCache(cache))
+	// the context may be done, closing has to happen regardless
+	defer r.Close(context.Background())
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		return setupFailed(err)
+	}
+	compiled, err := r.CompileModule(ctx, module)
+	if err != nil {
+		return setupFailed(err)
+	}
+
+	cfg := wazero.NewModuleConfig().
+		WithArgs("main").
+		WithStdout(stdout).
+		WithStderr(stderr).
+		// the clocks and the random source are fake unless set
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader).
+		// _start is called below, so that the memory can be read after the program has exited
+		WithStartFunctions()
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		cfg = cfg.WithEnv(k, v)
+	}
+	mod, err := r.InstantiateModule(ctx, compiled, cfg)
+	if err != nil {
+		return setupFailed(err)
+	}
+	start := mod.ExportedFunction("_start")
+	if start == nil {
+		return setupFailed(errors.New("the module is not a wasip1 command"))
+	}
+
+	left := fuel(limits.Calls)
+	begin := time.Now()
+	_, err = start.Call(context.WithValue(ctx, fuelKey{}, &left))
+	res := &protocol.Result{Stage: protocol.StageRun, WallTime: time.Since(begin).Microseconds()}
+	// the memory never shrinks, its size is the peak
+	if mem := mod.Memory(); mem != nil {
+		res.PeakMemory = int64(mem.Size())
+	}
+
+	var exit *sys.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exit):
+		switch exit.ExitCode() {
+		case sys.ExitCodeDeadlineExceeded:
+			res.ExitCode, res.Signal, res.Timeout = -1, "killed", protocol.TimeoutWall
+		case sys.ExitCodeContextCanceled:
+			res.ExitCode, res.Signal = -1, "killed"
+		default:
+			res.ExitCode = int(exit.ExitCode())
+		}
+	case errors.Is(err, errOutOfFuel):
+		// the calls stand for the CPU time of a native program
+		res.ExitCode, res.Signal, res.Timeout = -1, "killed", protocol.TimeoutCPU
+	default:
+		// the program trapped, like a crash of a native one
+		_, _ = fmt.Fprintln(stderr, err)
+		res.ExitCode, res.Signal = -1, "trap"
+	}
+	return res
+}
+
+func setupFailed(err error) *protocol.Result {
+	return &protocol.Result{Stage: protocol.StageSetup, ExitCode: 1, Error: err.Error()}
+}
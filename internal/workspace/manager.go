@@ -0,0 +1,66 @@
+package workspace
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"path/filepath"
+	"sync"
+)
+
+// Manager holds one pool per toolchain, versions sharing a GOROOT share the pool.
+type Manager struct {
+	goRoots map[string]string // version -> GOROOT
+
+	mu    sync.Mutex
+	pools map[string]*Pool // GOROOT -> pool
+	dir   string
+	cache string
+	size  int
+}
+
+func NewManager(goRoots map[string]string, dir, cacheDir string, size int) *Manager {
+	return &Manager{
+		goRoots: goRoots,
+		pools:   make(map[string]*Pool),
+		dir:     dir,
+		cache:   cacheDir,
+		size:    size,
+	}
+}
+
+// Get returns a workspace for the GOROOT.
+func (m *Manager) Get(ctx context.Context, goRoot string) (*Workspace, error) {
+	return m.pool(goRoot).Get(ctx)
+}
+
+// WarmAll prepares the pools of every known toolchain, failures are only logged
+// since the pool tries again on its first use.
+func (m *Manager) WarmAll() {
+	var wg sync.WaitGroup
+	for _, goRoot := range m.goRoots {
+		wg.Add(1)
+		go func(p *Pool) {
+			defer wg.Done()
+			if err := p.Warm(); err != nil {
+				log.Printf("failed to warm the workspaces of %s: %s", p.goRoot, err)
+			}
+		}(m.pool(goRoot))
+	}
+	wg.Wait()
+}
+
+func (m *Manager) pool(goRoot string) *Pool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.pools[goRoot]
+	if !ok {
+		sum := sha256.Sum256([]byte(goRoot))
+		key := hex.EncodeToString(sum[:4])
+		p = NewPool(goRoot, filepath.Join(m.dir, key), filepath.Join(m.cache, key), m.size)
+		m.pools[goRoot] = p
+	}
+	return p
+}
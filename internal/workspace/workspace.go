@@ -0,0 +1,273 @@
+package workspace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	baseCacheName = "base"
+	outputSuffix  = "-d" // of the outputs in a build cache, the index entries of the actions end in -a
+	dirPrefix     = "ws-"
+	goModFileName = "go.mod"
+	moduleName    = "sandbox"
+)
+
+// Workspace is a module directory initialized for one run, with its own GOCACHE that
+// shares the compiled standard library with every other workspace of the same toolchain, see cloneCache.
+type Workspace struct {
+	Dir   string
+	Cache string
+	pool  *Pool
+}
+
+// Env is the environment for the go command building in the workspace.
+func (w *Workspace) Env() []string {
+	return append(w.pool.env(),
+		"GOCACHE="+w.Cache,
+	)
+}
+
+// Release discards the workspace, a fresh one takes its place in the pool.
+func (w *Workspace) Release() {
+	go func() {
+		w.pool.remove(w)
+		w.pool.refill()
+	}()
+}
+
+// Pool hands out pre-initialized workspaces of one toolchain.
+type Pool struct {
+	goRoot   string
+	dir      string // parent of the workspaces
+	cacheDir string // parent of the base cache and the per workspace caches
+	size     int
+
+	mu        sync.Mutex
+	warmed    bool
+	warming   chan struct{} // closed once the warming in progress ended, nil if there is none
+	goVersion string
+	ready     chan *Workspace
+}
+
+func NewPool(goRoot, dir, cacheDir string, size int) *Pool {
+	// the go command requires an absolute GOCACHE
+	if abs, err := filepath.Abs(cacheDir); err == nil {
+		cacheDir = abs
+	}
+	return &Pool{
+		goRoot:   goRoot,
+		dir:      dir,
+		cacheDir: cacheDir,
+		size:     size,
+		ready:    make(chan *Workspace, size),
+	}
+}
+
+// Warm builds the standard library into the base cache and fills the pool, once it succeeded it is a no-op.
+// The build happens without the lock, the callers meanwhile wait for it instead of building too.
+func (p *Pool) Warm() error {
+	p.mu.Lock()
+	for !p.warmed && p.warming != nil {
+		warming := p.warming
+		p.mu.Unlock()
+		<-warming
+		p.mu.Lock()
+	}
+	if p.warmed {
+		p.mu.Unlock()
+		return nil
+	}
+	warming := make(chan struct{})
+	p.warming = warming
+	p.mu.Unlock()
+
+	goVersion, err := p.warm()
+
+	p.mu.Lock()
+	if err == nil {
+		p.goVersion = goVersion
+		p.warmed = true
+	}
+	p.warming = nil
+	close(warming)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < p.size; i++ {
+		p.refill()
+	}
+	return nil
+}
+
+// Get returns a ready workspace, or initializes one if the pool has run dry.
+func (p *Pool) Get(ctx context.Context) (*Workspace, error) {
+	if err := p.Warm(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	select {
+	case w := <-p.ready:
+		return w, nil
+	default:
+		return p.create()
+	}
+}
+
+// warm builds the standard library into the base cache and returns the Go version of the toolchain.
+func (p *Pool) warm() (string, error) {
+	// the code and the build cache of the runs are private to the server and the runner,
+	// the programs run as other users
+	for _, dir := range []string{p.dir, p.cacheDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", err
+		}
+		if err := os.Chmod(dir, 0700); err != nil {
+			return "", err
+		}
+	}
+
+	out, err := exec.Command(p.goBin(), "env", "GOVERSION").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the go version of %s: %w", p.goRoot, err)
+	}
+	// go1.24.3 -> 1.24.3
+	goVersion := strings.TrimPrefix(strings.TrimSpace(string(out)), "go")
+
+	base := filepath.Join(p.cacheDir, baseCacheName)
+	cmd := exec.Command(p.goBin(), "build", "std")
+	cmd.Env = append(p.env(), "GOCACHE="+base)
+	if out, err = cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to warm the build cache: %w: %s", err, out)
+	}
+
+	// the base cache is never written again, the workspace caches only link the outputs, see cloneCache
+	err = filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return os.Chmod(path, 0444)
+	})
+	return goVersion, err
+}
+
+func (p *Pool) refill() {
+	w, err := p.create()
+	if err != nil {
+		log.Printf("failed to create workspace: %s", err)
+		return
+	}
+	select {
+	case p.ready <- w:
+	default:
+		// the pool is full already
+		p.remove(w)
+	}
+}
+
+func (p *Pool) create() (*Workspace, error) {
+	dir, err := os.MkdirTemp(p.dir, dirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	w := &Workspace{
+		Dir:   dir,
+		Cache: filepath.Join(p.cacheDir, filepath.Base(dir)),
+		pool:  p,
+	}
+
+	goMod := fmt.Sprintf("module %s\n\ngo %s\n", moduleName, p.goVersion)
+	if err = os.WriteFile(filepath.Join(dir, goModFileName), []byte(goMod), 0644); err != nil {
+		p.remove(w)
+		return nil, err
+	}
+	if err = cloneCache(filepath.Join(p.cacheDir, baseCacheName), w.Cache); err != nil {
+		p.remove(w)
+		return nil, err
+	}
+	return w, nil
+}
+
+func (p *Pool) remove(w *Workspace) {
+	for _, dir := range []string{w.Dir, w.Cache} {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("failed to remove %s: %s", dir, err)
+		}
+	}
+}
+
+func (p *Pool) goBin() string {
+	return filepath.Join(p.goRoot, "bin", "go")
+}
+
+// env must match between warming and building, otherwise the cached standard library is not reused.
+func (p *Pool) env() []string {
+	env := []string{
+		"GOROOT=" + p.goRoot,
+		"PATH=" + filepath.Join(p.goRoot, "bin") + ":" + os.Getenv("PATH"),
+		"CGO_ENABLED=0",
+		"GOTOOLCHAIN=local",
+		"GOFLAGS=-mod=mod",
+	}
+	for _, key := range []string{"HOME", "GOPATH", "GOMODCACHE"} {
+		if v := os.Getenv(key); v != "" {
+			env = append(env, key+"="+v)
+		}
+	}
+	return env
+}
+
+// cloneCache makes dst a build cache with the entries of src. The outputs, the bulk of it, are hard linked,
+// which is far cheaper than copying them: they are named by the hash of their content, so the go command can
+// only write the same bytes to them. Everything else, the index entries of the actions and trim.txt, is written
+// in place, so it is copied: the read-only mode of the base cache does not stop root writing through a link.
+func cloneCache(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case strings.HasSuffix(d.Name(), outputSuffix):
+			return os.Link(path, target)
+		default:
+			return copyFile(path, target)
+		}
+	})
+}
+
+// copyFile copies src to a new, writable dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
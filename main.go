@@ -1,9 +1,14 @@
 package main
 
 import (
+	"flag"
 	"github.com/gin-gonic/gin"
 	"github.com/tianqi-wen_frgr/go-sandbox/internal/config"
 	"github.com/tianqi-wen_frgr/go-sandbox/internal/handlers"
+	"github.com/tianqi-wen_frgr/go-sandbox/internal/modproxy"
+	"log"
+	"net/http"
+	"os"
 	// "github.com/tianqi-wen_frgr/go-sandbox/internal/worker"
 	"time"
 )
@@ -22,6 +27,26 @@ func init() {
 }
 
 func main() {
+	// admin commands, e.g. ./server modproxy list, and the runner, ./server runner -addr :3100
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "modproxy":
+			if err := modproxy.Admin(modproxy.Default(), os.Args[2:], os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+		case "runner":
+			runner(os.Args[2:])
+		default:
+			log.Fatalf("unknown command: %s", os.Args[1])
+		}
+		return
+	}
+
+	// the runners build and run the programs if there are any
+	if len(config.List(config.RunnersKey)) == 0 {
+		startSandbox()
+	}
+
 	r := gin.Default()
 
 	// a global timeout middleware as a safety net
@@ -36,9 +61,47 @@ func main() {
 	r.POST("/snippets", timeout, handlers.ShareSnippet)
 	r.GET("/snippets/:id", timeout, handlers.FetchSnippet)
 	r.POST("/execute", handlers.Execute)
+	r.POST("/executions", handlers.CreateExecution)
+	r.GET("/executions/:id", timeout, handlers.GetExecution)
+	r.DELETE("/executions/:id", timeout, handlers.CancelExecution)
+	r.GET("/executions/:id/events", handlers.ExecutionEvents)
+	r.POST("/compile", handlers.Compile)
+	r.GET("/wasm/:id", timeout, handlers.FetchWasm)
+	r.GET("/wasm_exec.js", timeout, handlers.WasmExec)
+	r.GET("/pprof/:id", timeout, handlers.FetchPprof)
+	r.GET("/trace/:id", timeout, handlers.FetchTrace)
 	r.GET("/source", handlers.FetchSource)
+	r.GET("/doc", timeout, handlers.PackageDoc)
 
 	r.GET("/ws", handlers.LspHandler())
+	r.GET("/collab/:id", handlers.CollabHandler())
 
 	r.Run(config.ApiServerPort)
 }
+
+// runner serves the builds and runs of the API servers, which list it in RUNNERS.
+func runner(args []string) {
+	flags := flag.NewFlagSet("runner", flag.ExitOnError)
+	addr := flags.String("addr", config.RunnerAddr, "address the runner listens on")
+	flags.Parse(args)
+
+	handler, err := handlers.Runner()
+	if err != nil {
+		log.Fatal(err)
+	}
+	startSandbox()
+	log.Printf("runner listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, handler))
+}
+
+// startSandbox prepares the builds and runs of the programs in the background.
+func startSandbox() {
+	// build caches and workspaces
+	go handlers.WarmUp()
+
+	// the module proxy for the sandbox builds
+	go func() {
+		addr := config.String(config.ModProxyAddrKey, config.ModProxyAddr)
+		log.Fatal(http.ListenAndServe(addr, modproxy.NewServer(modproxy.Default())))
+	}()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	seccomp "github.com/seccomp/libseccomp-golang"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	violationPrefix = "SANDBOX_VIOLATION:" // a line on stderr naming a syscall the program was denied
+	auditAPILevel   = 6                    // seccomp notifications need this API level of libseccomp
+	// the pipes of the listener handover are these file descriptors in the init stage
+	auditFdOut = 3
+	auditAckIn = 4
+)
+
+// auditor answers the denied syscalls of the program with EPERM and reports each of them once.
+// The listener is created by the filter in the init stage, it is handed over to the runner before the program starts:
+// the init stage writes the number of its descriptor, the runner copies it with pidfd_getfd and acknowledges.
+type auditor struct {
+	fdOut, ackIn *os.File // the ends of the init stage
+	fdIn, ackOut *os.File // the ends of the runner
+	reported     map[string]bool
+}
+
+// auditSupported reports whether the kernel and libseccomp support handing over a seccomp listener.
+func auditSupported() bool {
+	if api, err := seccomp.GetAPI(); err != nil || api < auditAPILevel {
+		return false
+	}
+	pidfd, err := unix.PidfdOpen(os.Getpid(), 0)
+	if err != nil {
+		return false
+	}
+	defer unix.Close(pidfd)
+	fd, err := unix.PidfdGetfd(pidfd, 0, 0)
+	if err != nil {
+		return false
+	}
+	unix.Close(fd)
+	return true
+}
+
+func newAuditor() (*auditor, error) {
+	fdIn, fdOut, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	ackIn, ackOut, err := os.Pipe()
+	if err != nil {
+		fdIn.Close()
+		fdOut.Close()
+		return nil, err
+	}
+	return &auditor{
+		fdOut: fdOut, ackIn: ackIn,
+		fdIn: fdIn, ackOut: ackOut,
+		reported: make(map[string]bool),
+	}, nil
+}
+
+// files are passed to the init stage, in the order of auditFdOut and auditAckIn.
+func (a *auditor) files() []*os.File {
+	return []*os.File{a.fdOut, a.ackIn}
+}
+
+// attach takes over the listener of the started init stage and starts answering it.
+// If it fails, the init stage exits before running the program.
+func (a *auditor) attach(pid int) error {
+	a.fdOut.Close()
+	a.ackIn.Close()
+	defer a.fdIn.Close()
+	defer a.ackOut.Close()
+
+	line, err := bufio.NewReader(a.fdIn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("read listener: %w", err)
+	}
+	remote, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		return fmt.Errorf("read listener: %w", err)
+	}
+
+	pidfd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		return fmt.Errorf("pidfd_open: %w", err)
+	}
+	defer unix.Close(pidfd)
+	fd, err := unix.PidfdGetfd(pidfd, remote, 0)
+	if err != nil {
+		return fmt.Errorf("pidfd_getfd: %w", err)
+	}
+
+	if _, err = a.ackOut.Write([]byte{1}); err != nil {
+		unix.Close(fd)
+		return err
+	}
+	go a.serve(seccomp.ScmpFd(fd))
+	return nil
+}
+
+func (a *auditor) serve(fd seccomp.ScmpFd) {
+	for {
+		req, err := seccomp.NotifReceive(fd)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ENOENT) {
+				continue
+			}
+			return
+		}
+		// the process may be gone already, then there is nobody to answer
+		_ = seccomp.NotifRespond(fd, &seccomp.ScmpNotifResp{ID: req.ID, Error: int32(syscall.EPERM)})
+
+		name, err := req.Data.Syscall.GetName()
+		if err != nil {
+			name = fmt.Sprintf("syscall %d", req.Data.Syscall)
+		}
+		a.report(name)
+	}
+}
+
+func (a *auditor) report(name string) {
+	if a.reported[name] {
+		return
+	}
+	a.reported[name] = true
+	_, _ = fmt.Fprintf(os.Stderr, "\n%s%s\n", violationPrefix, name)
+}
+
+// handOverListener is the init stage side of attach, only syscalls that every profile allows are used.
+func handOverListener(fd seccomp.ScmpFd) error {
+	if _, err := syscall.Write(auditFdOut, []byte(strconv.Itoa(int(fd))+"\n")); err != nil {
+		return err
+	}
+	ack := make([]byte, 1)
+	n, err := syscall.Read(auditAckIn, ack)
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return errors.New("the runner did not take the listener")
+	}
+	_ = syscall.Close(auditFdOut)
+	_ = syscall.Close(auditAckIn)
+	// the runner has its own copy now
+	return syscall.Close(int(fd))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	cgroupRoot        = "/sys/fs/cgroup"
+	cgroupParentKey   = "SANDBOX_CGROUP_PARENT" // a delegated cgroup the runs are created in
+	cgroupDefaultName = "sandbox"
+	cgroupRunPrefix   = "run-"
+	cgroup2Magic      = 0x63677270
+)
+
+// cgroup is the cgroup v2 of one run, it limits the user program along with every process it forks.
+type cgroup struct {
+	path string
+	dir  *os.File // passed to clone3 so that the child starts inside the cgroup
+}
+
+// cgroupStats is read from the cgroup after the run.
+type cgroupStats struct {
+	PeakMemory int64 // bytes
+	CPUUser    time.Duration
+	CPUSystem  time.Duration
+	OOMKilled  bool
+}
+
+// newCgroup creates the cgroup of a run, an error means cgroups are not available or not delegated to us.
+func newCgroup(ioDir string) (*cgroup, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(cgroupRoot, &fs); err != nil || fs.Type != cgroup2Magic {
+		return nil, errors.New("cgroup v2 is not mounted")
+	}
+
+	parent := os.Getenv(cgroupParentKey)
+	if parent == "" {
+		parent = filepath.Join(cgroupRoot, cgroupDefaultName)
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, err
+	}
+	// the parent has no processes of its own, so the controllers can be enabled for its children
+	if err := writeFile(filepath.Join(parent, "cgroup.subtree_control"), "+memory +pids +cpu +io"); err != nil {
+		// io is not always available, the others are required
+		if err = writeFile(filepath.Join(parent, "cgroup.subtree_control"), "+memory +pids +cpu"); err != nil {
+			return nil, err
+		}
+	}
+	sweepCgroups(parent)
+
+	path, err := os.MkdirTemp(parent, cgroupRunPrefix)
+	if err != nil {
+		return nil, err
+	}
+	cg := &cgroup{path: path}
+
+	limits := map[string]string{
+		"memory.max":      strconv.Itoa(sandboxMemoryLimit),
+		"memory.swap.max": "0",
+		"pids.max":        strconv.Itoa(sandboxPidsLimit),
+		"cpu.max":         fmt.Sprintf("%d %d", sandboxCPUQuota, sandboxCPUPeriod),
+	}
+	for file, value := range limits {
+		if err = writeFile(filepath.Join(path, file), value); err != nil {
+			cg.close()
+			return nil, fmt.Errorf("failed to set %s: %w", file, err)
+		}
+	}
+	// best effort, only if the io controller is there
+	if dev, e := deviceOf(ioDir); e == nil {
+		_ = writeFile(filepath.Join(path, "io.max"), fmt.Sprintf("%s rbps=%d wbps=%d riops=%d wiops=%d",
+			dev, sandboxIOBytesLimit, sandboxIOBytesLimit, sandboxIOOpsLimit, sandboxIOOpsLimit))
+	}
+
+	if cg.dir, err = os.Open(path); err != nil {
+		cg.close()
+		return nil, err
+	}
+	return cg, nil
+}
+
+// apply makes the child start directly in the cgroup, no fork of it can escape.
+func (cg *cgroup) apply(attr *syscall.SysProcAttr) {
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(cg.dir.Fd())
+}
+
+func (cg *cgroup) stats() cgroupStats {
+	var s cgroupStats
+	if b, err := os.ReadFile(filepath.Join(cg.path, "memory.peak")); err == nil {
+		s.PeakMemory, _ = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	}
+	cpu := readKeyValues(filepath.Join(cg.path, "cpu.stat"))
+	s.CPUUser = time.Duration(cpu["user_usec"]) * time.Microsecond
+	s.CPUSystem = time.Duration(cpu["system_usec"]) * time.Microsecond
+	s.OOMKilled = readKeyValues(filepath.Join(cg.path, "memory.events"))["oom_kill"] > 0
+	return s
+}
+
+// close kills whatever is left in the cgroup and removes it.
+func (cg *cgroup) close() {
+	_ = writeFile(filepath.Join(cg.path, "cgroup.kill"), "1")
+	if cg.dir != nil {
+		cg.dir.Close()
+	}
+	// the processes take a moment to be gone, a leftover is swept by a later run
+	for i := 0; i < 10; i++ {
+		if err := os.Remove(cg.path); !errors.Is(err, syscall.EBUSY) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// sweepCgroups removes the cgroups of earlier runs that have no processes left.
+func sweepCgroups(parent string) {
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), cgroupRunPrefix) {
+			continue
+		}
+		path := filepath.Join(parent, e.Name())
+		if procs, err := os.ReadFile(filepath.Join(path, "cgroup.procs")); err == nil && len(procs) == 0 {
+			_ = os.Remove(path)
+		}
+	}
+}
+
+func writeFile(path, value string) error {
+	return os.WriteFile(path, []byte(value), 0644)
+}
+
+func readKeyValues(path string) map[string]int64 {
+	out := make(map[string]int64)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return out
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if key, value, ok := strings.Cut(line, " "); ok {
+			out[key], _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return out
+}
+
+// deviceOf returns the "major:minor" of the block device the path is on.
+func deviceOf(path string) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return "", err
+	}
+	dev := uint64(st.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	return fmt.Sprintf("%d:%d", major, minor), nil
+}
@@ -2,4 +2,7 @@ module github.com/77Vincent/go-sandbox/sandbox
 
 go 1.23.4
 
-require github.com/seccomp/libseccomp-golang v0.10.0
+require (
+	github.com/seccomp/libseccomp-golang v0.10.0
+	golang.org/x/sys v0.31.0
+)
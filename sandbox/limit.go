@@ -3,99 +3,133 @@ package main
 import (
 	"fmt"
 	"syscall"
+	"unsafe"
 
 	seccomp "github.com/seccomp/libseccomp-golang"
+	"golang.org/x/sys/unix"
 )
 
-// SetLimits applies CPU and memory resource limits to the current process.
-func SetLimits() error {
+// SetLimits applies resource limits to the current process, the init stage of the program.
+// The CPU time is always limited. Without a cgroup, noCgroup, the address space and the processes are limited too:
+// the address space limit fails programs that reserve more virtual memory than they use, so a cgroup is preferred,
+// and the process limit, which counts the threads of the user of the run, contains fork bombs.
+func SetLimits(noCgroup bool) error {
 	// CPU limit (seconds)
 	rlimCPU := &syscall.Rlimit{Cur: sandboxCPUTimeLimit, Max: sandboxCPUTimeLimit}
 	if err := syscall.Setrlimit(syscall.RLIMIT_CPU, rlimCPU); err != nil {
 		return fmt.Errorf("failed to set RLIMIT_CPU: %w", err)
 	}
 
+	if !noCgroup {
+		return nil
+	}
+
 	// Memory limit (bytes)
 	rlimMem := &syscall.Rlimit{Cur: sandboxMemoryLimit, Max: sandboxMemoryLimit}
 	if err := syscall.Setrlimit(syscall.RLIMIT_AS, rlimMem); err != nil {
 		return fmt.Errorf("failed to set RLIMIT_AS: %w", err)
 	}
 
+	// Processes and threads, like pids.max of the cgroup
+	rlimProcs := &unix.Rlimit{Cur: sandboxPidsLimit, Max: sandboxPidsLimit}
+	if err := unix.Setrlimit(unix.RLIMIT_NPROC, rlimProcs); err != nil {
+		return fmt.Errorf("failed to set RLIMIT_NPROC: %w", err)
+	}
+
 	return nil
 }
 
-// SetupSeccomp configures a seccomp filter that default-denies all syscalls
-// and then whitelists only the minimal set needed for Go's runtime, process
-// creation, and controlled file I/O within the sandbox.
-func SetupSeccomp() error {
-	// Default-deny: any non-whitelisted syscall returns EPERM
-	filter, err := seccomp.NewFilter(seccomp.ActErrno.SetReturnCode(int16(syscall.EPERM)))
+// SetupSeccomp loads a seccomp filter that default-denies all syscalls but the ones the profile allows.
+// With audit, denied syscalls are sent to a user-space listener instead, whose file descriptor is returned,
+// the listener decides how they fail.
+// Unless the profile allows them, clone only starts threads and execve only runs path, the program being started.
+func SetupSeccomp(p *Profile, audit bool, path *byte) (seccomp.ScmpFd, error) {
+	deny := seccomp.ActErrno.SetReturnCode(int16(syscall.EPERM))
+	if audit {
+		deny = seccomp.ActNotify
+	}
+	filter, err := seccomp.NewFilter(deny)
 	if err != nil {
-		return fmt.Errorf("seccomp.NewFilter: %w", err)
+		return -1, fmt.Errorf("seccomp.NewFilter: %w", err)
 	}
 
-	// Unified whitelist of syscalls to allow
-	allowList := []string{
-		// Basic I/O and process control
-		"read", "write", "exit", "exit_group",
-		// Signals
-		"rt_sigreturn", "rt_sigaction", "sigaction", "rt_sigprocmask", "sigaltstack",
-		// Memory and timing
-		"futex", "nanosleep", "clock_gettime",
-		"mmap", "munmap", "mprotect", "brk", "arch_prctl",
-		// File descriptor operations
-		"close", "fstat", "lseek",
-		// Process info and limits
-		"getpid", "gettid", "prlimit64",
-		// Polling and events
-		"epoll_create1", "epoll_ctl", "epoll_pwait", "eventfd2", "eventfd",
-		// Process spawning and reaping
-		"clone", "clone3", "execve", "execveat", "wait4", "waitid",
-		// fcntl and pipes for os/exec
-		"fcntl", "pipe", "pipe2", "dup", "dup2", "dup3",
-		// stats and lookup variants
-		"openat2", "statx", "fstatx", "fstatat", "fstatat64",
-		// Networking for HTTP server
-		"socket", "bind", "listen", "accept", "accept4", "connect",
-		"getsockopt", "setsockopt", "getsockname", "getpeername",
-	}
-	for _, name := range allowList {
+	for _, name := range p.Syscalls {
+		// names that do not exist on this architecture are skipped
 		if sc, e := seccomp.GetSyscallFromName(name); e == nil {
 			if err = filter.AddRule(sc, seccomp.ActAllow); err != nil {
-				return fmt.Errorf("allow syscall %s: %w", name, err)
+				return -1, fmt.Errorf("allow syscall %s: %w", name, err)
 			}
 		}
 	}
 
 	// Controlled file I/O: allow only safe open flags (mask-based)
-	const mask = syscall.O_ACCMODE | syscall.O_CREAT
-	allowedFlags := []uint64{
-		syscall.O_RDONLY,
-		syscall.O_WRONLY,
-		syscall.O_RDWR,
-		syscall.O_CREAT | syscall.O_WRONLY,
+	allowedFlags, err := p.openFlags()
+	if err != nil {
+		return -1, err
 	}
-	for _, name := range []string{"open", "openat"} {
+	for name, arg := range openFlagsArg {
 		sc, e := seccomp.GetSyscallFromName(name)
 		if e != nil {
 			continue
 		}
 		for _, want := range allowedFlags {
 			cond := seccomp.ScmpCondition{
-				Argument: 1,
+				Argument: arg,
 				Op:       seccomp.CompareMaskedEqual,
-				Operand1: want,
-				Operand2: mask,
+				Operand1: openFlagsMask,
+				Operand2: want,
 			}
 			if err = filter.AddRuleConditional(sc, seccomp.ActAllow, []seccomp.ScmpCondition{cond}); err != nil {
-				return fmt.Errorf("allow %s flags %#x: %w", name, want, err)
+				return -1, fmt.Errorf("allow %s flags %#x: %w", name, want, err)
+			}
+		}
+	}
+
+	// the threads of the Go runtime, the filter sees the flags in the first argument of clone on every architecture but s390x
+	if !p.allows("clone") {
+		cond := seccomp.ScmpCondition{
+			Argument: 0,
+			Op:       seccomp.CompareMaskedEqual,
+			Operand1: syscall.CLONE_THREAD,
+			Operand2: syscall.CLONE_THREAD,
+		}
+		if err = filter.AddRuleConditional(syscall.SYS_CLONE, seccomp.ActAllow, []seccomp.ScmpCondition{cond}); err != nil {
+			return -1, fmt.Errorf("allow clone of threads: %w", err)
+		}
+	}
+
+	// the exec of the program itself, told by the pointer to its path; anything exec'd from that address later
+	// still runs under this filter
+	if !p.allows("execve") {
+		cond := seccomp.ScmpCondition{
+			Argument: 0,
+			Op:       seccomp.CompareEqual,
+			Operand1: uint64(uintptr(unsafe.Pointer(path))),
+		}
+		if err = filter.AddRuleConditional(syscall.SYS_EXECVE, seccomp.ActAllow, []seccomp.ScmpCondition{cond}); err != nil {
+			return -1, fmt.Errorf("allow execve of the program: %w", err)
+		}
+	}
+
+	// the flags of openat2 and clone3 are in a struct the filter cannot read, they fail as if the kernel had none,
+	// so that the callers fall back to openat and clone
+	for _, name := range []string{"openat2", "clone3"} {
+		if p.allows(name) {
+			continue
+		}
+		if sc, e := seccomp.GetSyscallFromName(name); e == nil {
+			if err = filter.AddRule(sc, seccomp.ActErrno.SetReturnCode(int16(syscall.ENOSYS))); err != nil {
+				return -1, fmt.Errorf("deny %s: %w", name, err)
 			}
 		}
 	}
 
-	// 8) Load the filter into the kernel
+	// Load the filter into the kernel
 	if err = filter.Load(); err != nil {
-		return fmt.Errorf("seccomp.Load: %w", err)
+		return -1, fmt.Errorf("seccomp.Load: %w", err)
 	}
-	return nil
+	if !audit {
+		return -1, nil
+	}
+	return filter.GetNotifFd()
 }
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"go/parser"
+	"go/token"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -17,100 +23,393 @@ const (
 	timeoutExitCode     = 124
 	sandboxCPUTimeLimit = 7                      // seconds
 	sandboxMemoryLimit  = 2 * 1024 * 1024 * 1024 // bytes
+	sandboxPidsLimit    = 64
+	sandboxCPUQuota     = 100000           // microseconds per period, one CPU
+	sandboxCPUPeriod    = 100000           // microseconds
+	sandboxIOBytesLimit = 50 * 1024 * 1024 // bytes per second
+	sandboxIOOpsLimit   = 1000             // operations per second
 	tmpFileName         = "main.go"
-	tmpOutputDir        = "/app/sandbox-temp"
 )
 
+// tmpOutputDir holds the builds, the cgroups and the UID locks of the runs, see -temp-dir
+var tmpOutputDir = "/app/sandbox-temp"
+
+// deterministicEnv is added to the environment of a faketime program. The fake clock of the runtime starts at
+// a fixed time and sleeps advance it instantly, this fixes the seed of the top-level functions of math/rand.
+// Nothing fixes math/rand/v2, crypto/rand or the randomness of the runtime, they read the kernel's.
+var deterministicEnv = []string{"GODEBUG=randautoseed=0"}
+
+// wasmTargets are the GOOS values a program can be compiled for with -target, GOARCH is wasm
+var wasmTargets = map[string]bool{"js": true, "wasip1": true}
+
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatalf("Usage: %s <code-file>", os.Args[0])
+	if len(os.Args) > 1 && os.Args[1] == initCommand {
+		runInit(os.Args[2:])
+		return
 	}
+
 	var (
-		codeFile   = os.Args[1]
-		arr        = strings.Split(codeFile, "/")
-		moduleDir  = fmt.Sprintf("%s/%s", arr[0], arr[1])
+		profile   = flag.String("profile", defaultProfile, "seccomp profile of the program, see the profiles directory")
+		audit     = flag.Bool("audit", false, "report the syscalls the program is denied on stderr")
+		faketime  = flag.Bool("faketime", false, "run the program with a fake clock and a fixed seed of math/rand, its output is framed with the virtual time")
+		resultFd  = flag.Int("result-fd", syscall.Stderr, "descriptor the result of the run is written to as JSON")
+		term      = flag.String("term", "", "TERM of the program, for output rendered by a terminal emulator")
+		target    = flag.String("target", "", "only build the program, for the WebAssembly target: js or wasip1")
+		output    = flag.String("o", "", "file the program is built into with -target")
+		pprof     = flag.Bool("pprof", false, "profile the CPU and heap of the program into cpu.pprof and heap.pprof of -artifacts")
+		trace     = flag.Bool("trace", false, "trace the execution of the program into trace.out of -artifacts")
+		artifacts = flag.String("artifacts", "", "directory of the files of -pprof and -trace")
 	)
+	flag.StringVar(&tmpOutputDir, "temp-dir", tmpOutputDir, "directory of the builds and the state of the runs, shared by the runners of a host")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		log.Fatalf("Usage: %s [-profile name] [-audit] [-faketime] [-result-fd n] [-term name] [-pprof] [-trace] [-artifacts dir] [-temp-dir dir] [-target js|wasip1 -o file] <module-dir>", os.Args[0])
+	}
+	if *target != "" && (!wasmTargets[*target] || *output == "") {
+		log.Fatalf("Invalid target: %q, it takes one of js and wasip1, and -o", *target)
+	}
+	wrap := wrapping{Pprof: *pprof, Trace: *trace, Dir: *artifacts}
+	if wrap.enabled() && wrap.Dir == "" {
+		log.Fatalf("-pprof and -trace take -artifacts")
+	}
+	var (
+		moduleDir = flag.Arg(0)
+	)
+	if _, err := loadProfile(*profile); err != nil {
+		log.Fatalf("Invalid profile: %v", err)
+	}
+
+	// neither the build nor the program may write the result
+	if *resultFd > syscall.Stderr {
+		syscall.CloseOnExec(*resultFd)
+	}
+
+	var env []string
+	if *faketime {
+		env = append(env, deterministicEnv...)
+	}
+	if *term != "" {
+		env = append(env, "TERM="+*term)
+	}
+
+	var res result
+	if *target != "" {
+		res = compile(moduleDir, *target, *output)
+	} else {
+		res = run(moduleDir, *profile, *audit, *faketime, wrap, env)
+	}
+	if err := res.write(*resultFd); err != nil {
+		log.Printf("Failed to write the result: %v", err)
+	}
+	os.Exit(res.exitCode())
+}
+
+// run builds and runs the program and reports how it ended.
+// Only the child is limited, so the runner can always report, enforce the timeout and clean up.
+// With faketime, the program is built with the fake clock of the runtime, see deterministicEnv.
+// With wrap enabled, main of the program is wrapped to profile or trace it, see wrapMain.
+// env is added to the environment of the program.
+func run(moduleDir, profile string, audit, faketime bool, wrap wrapping, env []string) result {
+	// the server stops a run with SIGTERM, which ends the build or the program and cleans up as usual
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
 
 	// normal code flow
 	// 1. compile user code, generate an executable file
 	tmpDir, err := os.MkdirTemp(tmpOutputDir, "sandbox-build-")
 	if err != nil {
-		log.Fatalf("Failed to create temp directory: %v", err)
+		log.Printf("Failed to create temp directory: %v", err)
+		return setupFailed(err)
 	}
 	defer os.RemoveAll(tmpDir)
 
-	// init module if not exists
-	if _, err = os.Stat(fmt.Sprintf("%s/go.mod", moduleDir)); os.IsNotExist(err) {
-		// init module
-		cmd := exec.Command("go", "mod", "init", "sandbox")
-		cmd.Dir = moduleDir
-		if err = cmd.Run(); err != nil {
-			log.Fatalf("Failed to init module: %v", err)
-		}
-	}
-
-	// move the source code to the module dir
-	if err = os.Rename(codeFile, filepath.Join(moduleDir, tmpFileName)); err != nil {
-		log.Fatalf("Failed to move code file: %v", err)
+	if res, ok := prepare(ctx, moduleDir); !ok {
+		return res
 	}
-
-	// run go mod tidy
-	cmd := exec.Command("go", "mod", "tidy")
-	cmd.Dir = moduleDir
-	if err = cmd.Run(); err != nil {
-		log.Fatalf("Failed to tidy module: %v", err)
+	if wrap.enabled() {
+		if err = wrapMain(moduleDir, wrap); err != nil {
+			log.Printf("Failed to wrap main: %v", err)
+			return setupFailed(err)
+		}
 	}
 
 	binPath := filepath.Join(tmpDir, "userprog")
-	cmd = exec.Command("go", "build", "-o", binPath, ".")
+	buildArgs := []string{"build", "-o", binPath}
+	if faketime {
+		buildArgs = append(buildArgs, "-tags=faketime")
+	}
+	cmd := exec.CommandContext(ctx, "go", append(buildArgs, ".")...)
 	cmd.Dir = moduleDir
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err = cmd.Run(); err != nil {
-		log.Fatalf("Build error: %v", err)
+		log.Printf("Build error: %v", err)
+		res := result{Stage: stageBuild}
+		res.exited(cmd.ProcessState)
+		return res
+	}
+
+	// the working directory of the program when it runs without namespaces
+	workDir := filepath.Join(tmpDir, "work")
+	if err = os.Mkdir(workDir, 0700); err != nil {
+		log.Printf("Failed to create work directory: %v", err)
+		return setupFailed(err)
 	}
 
-	//if err = syscall.Setuid(65534); err != nil { // 65534 is typically 'nobody'
-	//	log.Fatalf("Failed to drop privileges: %v", err)
-	//}
+	// the program runs as a user of its own, which owns nothing but the binary and the work directory
+	user, err := allocateUser()
+	if err != nil {
+		log.Printf("Failed to allocate a user: %v", err)
+		return setupFailed(err)
+	}
+	defer user.release()
+	defer reclaim(workDir)
+	// others may pass through, but not list the runs
+	if err = os.Chmod(tmpDir, 0711); err == nil {
+		if err = user.own(binPath, 0500); err == nil {
+			err = user.own(workDir, 0700)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to drop privileges: %v", err)
+		return setupFailed(err)
+	}
 
-	if err = SetupSeccomp(); err != nil {
-		log.Fatalf("Failed to setup seccomp: %v", err)
+	// the cgroup limits the whole process tree of the program, rlimits are the fallback when it is not delegated
+	cg, err := newCgroup(tmpOutputDir)
+	if err == nil {
+		defer cg.close()
 	}
 
-	if err = SetLimits(); err != nil {
-		log.Fatalf("Failed to set resource limits: %v", err)
+	// the init stage mounts the sandbox root here
+	rootDir := filepath.Join(tmpDir, "root")
+	if err = os.Mkdir(rootDir, 0755); err != nil {
+		log.Printf("Failed to create sandbox root: %v", err)
+		return setupFailed(err)
 	}
 
 	// the execution timeout is same as the CPU timeout limit
-	ctx, cancel := context.WithTimeout(context.Background(), sandboxCPUTimeLimit*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, sandboxCPUTimeLimit*time.Second)
 	defer cancel()
 
-	// execute the built program
-	cmd = exec.CommandContext(ctx, binPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// denied syscalls are reported where the kernel supports it, otherwise they only fail
+	var aud *auditor
+	if audit && auditSupported() {
+		if aud, err = newAuditor(); err != nil {
+			log.Printf("Failed to setup audit: %v", err)
+			return setupFailed(err)
+		}
+	}
 
+	cfg := initConfig{
+		RootDir:     rootDir,
+		Binary:      binPath,
+		WorkDir:     workDir,
+		Isolated:    true,
+		Profile:     profile,
+		Audit:       aud != nil,
+		LimitMemory: cg == nil,
+		Pprof:       wrap.Pprof,
+		Env:         env,
+	}
+	var files []*os.File
+	if wrap.enabled() {
+		if files, err = createFiles(wrap); err != nil {
+			log.Printf("Failed to create the files of the program: %v", err)
+			return setupFailed(err)
+		}
+		defer closeFiles(files)
+	}
+
+	// execute the built program through the init stage in new namespaces, without them only if it is allowed
 	start := time.Now()
-	if err = cmd.Run(); err != nil {
-		// the program in the sandbox has to be ended due to the timeout
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			os.Exit(timeoutExitCode)
+	cmd = command(ctx, cg, user, cfg, aud, files)
+	if err = cmd.Start(); err != nil {
+		if os.Getenv(allowUnisolatedKey) != "1" {
+			log.Printf("Failed to create the namespaces: %v", err)
+			return setupFailed(fmt.Errorf("the namespaces of the sandbox cannot be created: %w", err))
+		}
+		cfg.Isolated = false
+		cmd = command(ctx, cg, user, cfg, aud, files)
+		err = cmd.Start()
+	}
+	if err == nil && aud != nil {
+		// the init stage exits by itself when this fails
+		if e := aud.attach(cmd.Process.Pid); e != nil {
+			log.Printf("Failed to attach seccomp listener: %v", e)
+		}
+	}
+	if err == nil {
+		err = cmd.Wait()
+	}
+	if err != nil && cmd.ProcessState == nil {
+		log.Printf("Failed to start: %v", err)
+		return setupFailed(err)
+	}
+
+	res := result{Stage: stageRun, WallTime: time.Since(start).Microseconds(), Unisolated: !cfg.Isolated}
+	res.exited(cmd.ProcessState)
+	switch {
+	// the program in the sandbox has to be ended due to the timeout
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		res.Timeout = timeoutWall
+	case cpuLimitExceeded(cmd.ProcessState):
+		res.Timeout = timeoutCPU
+	}
+
+	// the resource usage of the child process
+	if cg != nil {
+		s := cg.stats()
+		res.PeakMemory, res.OOMKilled = s.PeakMemory, s.OOMKilled
+		res.setCPU(s.CPUUser, s.CPUSystem)
+	} else {
+		ps := cmd.ProcessState
+		if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+			res.PeakMemory = ru.Maxrss * 1024
 		}
+		res.setCPU(ps.UserTime(), ps.SystemTime())
+	}
 
+	switch {
+	case res.Timeout != "":
+	case res.OOMKilled:
+		log.Printf("Execution error: out of memory, the limit is %d MB", sandboxMemoryLimit/1024/1024)
+	case err != nil:
 		log.Printf("Execution error: %s", err)
-		os.Exit(1)
+	}
+	return res
+}
+
+// prepare inits the module of the program if it has none and resolves its third-party imports.
+// The result is only set if it fails.
+func prepare(ctx context.Context, moduleDir string) (result, bool) {
+	// init module if not exists
+	if _, err := os.Stat(fmt.Sprintf("%s/go.mod", moduleDir)); os.IsNotExist(err) {
+		// init module
+		cmd := exec.CommandContext(ctx, "go", "mod", "init", "sandbox")
+		cmd.Dir = moduleDir
+		if err := cmd.Run(); err != nil {
+			log.Printf("Failed to init module: %v", err)
+			return setupFailed(err), false
+		}
 	}
 
-	duration := time.Since(start)
+	// run go mod tidy, only needed to resolve third-party imports
+	if needsTidy(filepath.Join(moduleDir, tmpFileName)) {
+		cmd := exec.CommandContext(ctx, "go", "mod", "tidy")
+		cmd.Dir = moduleDir
+		// the progress of the downloads is only of interest when it fails
+		var out bytes.Buffer
+		cmd.Stderr = &out
+		if err := cmd.Run(); err != nil {
+			// a module that is not allowed fails the build
+			_, _ = os.Stderr.Write(out.Bytes())
+			log.Printf("Build error: %v", err)
+			res := result{Stage: stageBuild}
+			res.exited(cmd.ProcessState)
+			return res, false
+		}
+	}
 
-	// get the resource usage of the child process
-	if ps := cmd.ProcessState; ps != nil {
-		if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
-			if _, err = fmt.Fprintf(os.Stderr, "STATS_INFO:%s;%d", duration, ru.Maxrss); err != nil {
-				log.Printf("Failed to write execution stats: %v", err)
-				os.Exit(1)
-			}
+	return result{}, true
+}
+
+// compile builds the program for the WebAssembly target into output, it is not run.
+// The result is of the build stage, it has succeeded if the exit code is 0.
+func compile(moduleDir, target, output string) result {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+
+	// the build runs in the module directory
+	output, err := filepath.Abs(output)
+	if err != nil {
+		return setupFailed(err)
+	}
+	if res, ok := prepare(ctx, moduleDir); !ok {
+		return res
+	}
+
+	start := time.Now()
+	// without the paths of the workspace the same code builds the same module
+	cmd := exec.CommandContext(ctx, "go", "build", "-trimpath", "-o", output, ".")
+	cmd.Dir = moduleDir
+	cmd.Env = append(os.Environ(), "GOOS="+target, "GOARCH=wasm")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil && cmd.ProcessState == nil {
+		log.Printf("Failed to start the build: %v", err)
+		return setupFailed(err)
+	}
+
+	res := result{Stage: stageBuild, WallTime: time.Since(start).Microseconds()}
+	res.exited(cmd.ProcessState)
+	res.setCPU(cmd.ProcessState.UserTime(), cmd.ProcessState.SystemTime())
+	if err != nil {
+		log.Printf("Build error: %v", err)
+	}
+	return res
+}
+
+// command returns the command that runs the init stage of the user program.
+// It runs as user. The files of the wrapper are passed after the descriptors of the auditor, see cpuProfileFd.
+func command(ctx context.Context, cg *cgroup, user *runUser, cfg initConfig, aud *auditor, files []*os.File) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "/proc/self/exe", initArgs(cfg)...)
+	cmd.Dir = cfg.WorkDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if aud != nil {
+		cmd.ExtraFiles = aud.files()
+	}
+	if files != nil {
+		// the descriptors of the auditor are closed without one
+		cmd.ExtraFiles = append(make([]*os.File, 2), files...)
+		if aud != nil {
+			copy(cmd.ExtraFiles, aud.files())
+		}
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Pdeathsig:  syscall.SIGKILL,
+		Credential: &syscall.Credential{Uid: uint32(user.UID), Gid: uint32(user.GID)},
+	}
+	if cfg.Isolated {
+		cmd.SysProcAttr = namespaceAttr(user)
+	}
+	if cg != nil {
+		cg.apply(cmd.SysProcAttr)
+	}
+	return cmd
+}
+
+// cpuLimitExceeded reports whether the program was killed by RLIMIT_CPU.
+func cpuLimitExceeded(ps *os.ProcessState) bool {
+	if ps == nil {
+		return false
+	}
+	ws, ok := ps.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return false
+	}
+	return ws.Signal() == syscall.SIGXCPU ||
+		ws.Signal() == syscall.SIGKILL && ps.UserTime()+ps.SystemTime() >= sandboxCPUTimeLimit*time.Second
+}
+
+// needsTidy reports whether the code imports anything outside the standard library.
+// The build reports the error itself if the file cannot be parsed.
+func needsTidy(codeFile string) bool {
+	f, err := parser.ParseFile(token.NewFileSet(), codeFile, nil, parser.ImportsOnly)
+	if err != nil {
+		return false
+	}
+	for _, imp := range f.Imports {
+		path, e := strconv.Unquote(imp.Path.Value)
+		if e != nil {
+			return false
+		}
+		// standard library paths have no dot in the first element
+		if first, _, _ := strings.Cut(path, "/"); strings.Contains(first, ".") {
+			return true
 		}
 	}
+	return false
 }
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	initCommand     = "init" // argument that makes the runner the init stage of the user program
+	sandboxHostname = "sandbox"
+	sandboxBinary   = "/userprog" // path of the user program inside the sandbox root
+	rootTmpfsSize   = "size=1m"   // the root only holds mount points and a few files in /etc
+	tmpTmpfsSize    = "size=64m"
+	// allowUnisolatedKey set to 1 lets programs run without namespaces when they cannot be created,
+	// sharing the network, mounts and processes of the host; such runs are reported as unisolated
+	allowUnisolatedKey = "SANDBOX_ALLOW_UNISOLATED"
+)
+
+// files of the host that are bind-mounted read-only into the sandbox root, missing ones are skipped
+var hostFiles = []string{
+	"/dev/null", "/dev/zero", "/dev/random", "/dev/urandom",
+	"/usr/share/zoneinfo", "/etc/ssl/certs",
+}
+
+// files that are written into the sandbox root
+var rootFiles = map[string]string{
+	"/etc/hosts":  "127.0.0.1 localhost\n::1 localhost\n",
+	"/etc/passwd": "root:x:0:0:root:/tmp:/sbin/nologin\n",
+	"/etc/group":  "root:x:0:\n",
+}
+
+// namespaceFlags are the namespaces the user program runs in, the user namespace makes it work without privileges
+const namespaceFlags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
+	syscall.CLONE_NEWNET | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC
+
+// namespaceAttr returns the attributes that start the init stage in new namespaces,
+// where the user of the run is root with no power outside of them.
+func namespaceAttr(user *runUser) *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Cloneflags:  namespaceFlags,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: user.UID, Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: user.GID, Size: 1}},
+		// the child becomes the user by switching to root of the namespace, dropping the groups of the runner
+		GidMappingsEnableSetgroups: true,
+		Credential:                 &syscall.Credential{Uid: 0, Gid: 0},
+		Pdeathsig:                  syscall.SIGKILL,
+	}
+}
+
+// initConfig is passed from the runner to the init stage.
+type initConfig struct {
+	RootDir     string   `json:"root_dir"` // where the sandbox root is mounted when isolated
+	Binary      string   `json:"binary"`
+	WorkDir     string   `json:"work_dir"` // HOME and TMPDIR of the program when it is not isolated
+	Isolated    bool     `json:"isolated"` // the init stage runs in new namespaces
+	Profile     string   `json:"profile"`
+	Audit       bool     `json:"audit"`        // hand over the seccomp listener, see auditor
+	LimitMemory bool     `json:"limit_memory"` // there is no cgroup, see SetLimits
+	Pprof       bool     `json:"pprof"`        // the program profiles itself, see profilingSyscalls
+	Env         []string `json:"env"`          // added to the environment of the program
+}
+
+// initArgs are the arguments of the init stage, see runInit.
+func initArgs(cfg initConfig) []string {
+	b, _ := json.Marshal(cfg)
+	return []string{initCommand, string(b)}
+}
+
+// runInit is the init stage, it runs in the child between fork and the user program.
+// When isolated, it builds the sandbox root and switches to it, then it applies the resource limits and
+// the seccomp profile to itself and replaces itself with the user program, so the runner is not limited by them.
+// With audit, the listener of the filter is handed over to the runner on the descriptors of the auditor.
+func runInit(args []string) {
+	var cfg initConfig
+	if len(args) != 1 || json.Unmarshal([]byte(args[0]), &cfg) != nil {
+		log.Fatalf("Usage: %s %s <config>", os.Args[0], initCommand)
+	}
+	var (
+		binPath = cfg.Binary
+	)
+	profile, err := loadProfile(cfg.Profile)
+	if err != nil {
+		log.Fatalf("Failed to load seccomp profile: %v", err)
+	}
+	if cfg.Pprof {
+		profile.Syscalls = append(profile.Syscalls, profilingSyscalls...)
+	}
+
+	// the filter is loaded on this thread, it must be the one that calls execve
+	runtime.LockOSThread()
+
+	if cfg.Isolated {
+		if err = setupRoot(cfg.RootDir, binPath); err != nil {
+			log.Fatalf("Failed to setup sandbox root: %v", err)
+		}
+		if err = syscall.Sethostname([]byte(sandboxHostname)); err != nil {
+			log.Fatalf("Failed to set hostname: %v", err)
+		}
+		if profile.Network {
+			if err = loopbackUp(); err != nil {
+				log.Fatalf("Failed to setup loopback network: %v", err)
+			}
+		}
+		if err = dropCapabilities(); err != nil {
+			log.Fatalf("Failed to drop capabilities: %v", err)
+		}
+		binPath = sandboxBinary
+	}
+
+	// setrlimit is not allowed by the profiles, the limits go first
+	if err = SetLimits(cfg.LimitMemory); err != nil {
+		log.Fatalf("Failed to set resource limits: %v", err)
+	}
+
+	home := "/tmp"
+	if !cfg.Isolated {
+		home = cfg.WorkDir
+	}
+	env := append([]string{"PATH=/", "HOME=" + home, "TMPDIR=" + home}, cfg.Env...)
+	path, err := syscall.BytePtrFromString(binPath)
+	if err != nil {
+		log.Fatalf("Failed to execute: %v", err)
+	}
+	argv, err := syscall.SlicePtrFromStrings([]string{binPath})
+	if err != nil {
+		log.Fatalf("Failed to execute: %v", err)
+	}
+	envv, err := syscall.SlicePtrFromStrings(env)
+	if err != nil {
+		log.Fatalf("Failed to execute: %v", err)
+	}
+
+	listener, err := SetupSeccomp(profile, cfg.Audit, path)
+	if err != nil {
+		log.Fatalf("Failed to setup seccomp: %v", err)
+	}
+	if cfg.Audit {
+		if err = handOverListener(listener); err != nil {
+			log.Fatalf("Failed to hand over the seccomp listener: %v", err)
+		}
+	}
+
+	// the filter allows execve for this path only, see SetupSeccomp
+	_, _, errno := syscall.RawSyscall(syscall.SYS_EXECVE,
+		uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&argv[0])), uintptr(unsafe.Pointer(&envv[0])))
+	log.Fatalf("Failed to execute: %v", errno)
+}
+
+// setupRoot makes a tmpfs in rootDir the root of the mount namespace, with the user program,
+// a few host files and a writable /tmp in it, everything else is read-only.
+func setupRoot(rootDir, binPath string) error {
+	// nothing mounted here propagates back to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+	if err := syscall.Mount("tmpfs", rootDir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, rootTmpfsSize+",mode=0755"); err != nil {
+		return fmt.Errorf("mount root: %w", err)
+	}
+
+	if err := bindReadOnly(binPath, filepath.Join(rootDir, sandboxBinary)); err != nil {
+		return err
+	}
+	for _, path := range hostFiles {
+		if err := bindReadOnly(path, filepath.Join(rootDir, path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	for path, content := range rootFiles {
+		target := filepath.Join(rootDir, path)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	tmp := filepath.Join(rootDir, "tmp")
+	if err := os.Mkdir(tmp, 0777); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", tmp, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, tmpTmpfsSize+",mode=1777"); err != nil {
+		return fmt.Errorf("mount /tmp: %w", err)
+	}
+	// proc of the new PID namespace, it is not allowed when the host hides parts of its own proc
+	procDir := filepath.Join(rootDir, "proc")
+	if err := os.Mkdir(procDir, 0555); err != nil {
+		return err
+	}
+	_ = syscall.Mount("proc", procDir, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+
+	// switch to the new root and detach the old one
+	oldRoot := filepath.Join(rootDir, ".old")
+	if err := os.Mkdir(oldRoot, 0700); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(rootDir, oldRoot); err != nil {
+		return fmt.Errorf("pivot root: %w", err)
+	}
+	if err := syscall.Chdir("/"); err != nil {
+		return err
+	}
+	if err := syscall.Unmount("/.old", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmount old root: %w", err)
+	}
+	if err := os.Remove("/.old"); err != nil {
+		return err
+	}
+	if err := remountReadOnly("/"); err != nil {
+		return err
+	}
+	return syscall.Chdir("/tmp")
+}
+
+// bindReadOnly bind-mounts source on target, creating the mount point.
+func bindReadOnly(source, target string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		err = os.Mkdir(target, 0755)
+	} else {
+		err = os.WriteFile(target, nil, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	if err = syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind %s: %w", source, err)
+	}
+	return remountReadOnly(target)
+}
+
+// remountReadOnly makes a mount read-only, the flags of the mount that are locked in a user namespace are kept.
+func remountReadOnly(target string) error {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(target, &fs); err != nil {
+		return err
+	}
+	// the ST_* flags of statfs have the same values as the MS_* flags of mount
+	const kept = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+		syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME
+	flags := uintptr(syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY) | uintptr(fs.Flags)&kept
+	if err := syscall.Mount("", target, "", flags, ""); err != nil {
+		return fmt.Errorf("remount %s read-only: %w", target, err)
+	}
+	return nil
+}
+
+// loopbackUp brings up the loopback interface, the only one in the network namespace.
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	var req struct {
+		Name  [syscall.IFNAMSIZ]byte
+		Flags uint16
+		_     [22]byte
+	}
+	copy(req.Name[:], "lo")
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return errno
+	}
+	req.Flags |= syscall.IFF_UP
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// dropCapabilities empties the bounding set, so that the user program gets no capabilities
+// in the namespaces even though it runs as their root.
+func dropCapabilities() error {
+	for c := 0; ; c++ {
+		if err := prctl(syscall.PR_CAPBSET_DROP, uintptr(c)); err != nil {
+			// past the last capability the kernel knows
+			if errors.Is(err, syscall.EINVAL) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func prctl(option int, arg uintptr) error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, uintptr(option), arg, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+const defaultProfile = "default"
+
+//go:embed profiles/*.json
+var profileFiles embed.FS
+
+// Profile is a seccomp profile, the syscalls it does not allow fail with EPERM.
+// A profile extends another one by adding to its syscalls and open flags.
+type Profile struct {
+	Description string   `json:"description"`
+	Extends     string   `json:"extends"`
+	Network     bool     `json:"network"`    // the program gets a loopback network
+	Syscalls    []string `json:"syscalls"`   // allowed unconditionally
+	OpenFlags   []string `json:"open_flags"` // allowed access modes of open and openat, e.g. "O_WRONLY|O_CREAT"
+}
+
+// the open flags a profile can name, they are compared under openFlagsMask
+var openFlagNames = map[string]uint64{
+	"O_RDONLY": syscall.O_RDONLY,
+	"O_WRONLY": syscall.O_WRONLY,
+	"O_RDWR":   syscall.O_RDWR,
+	"O_CREAT":  syscall.O_CREAT,
+}
+
+const openFlagsMask = syscall.O_ACCMODE | syscall.O_CREAT
+
+// openFlagsArg is the argument of the flags of the syscalls that open files, they are only allowed with open_flags.
+// openat2 is never allowed, see SetupSeccomp.
+var openFlagsArg = map[string]uint{
+	"open":   1,
+	"openat": 2,
+}
+
+// loadProfile returns the named profile with everything it extends merged in.
+func loadProfile(name string) (*Profile, error) {
+	b, err := profileFiles.ReadFile("profiles/" + name + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("unknown profile %q", name)
+	}
+	var p Profile
+	if err = json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+	if p.Extends != "" {
+		base, err := loadProfile(p.Extends)
+		if err != nil {
+			return nil, err
+		}
+		p.Syscalls = append(base.Syscalls, p.Syscalls...)
+		p.OpenFlags = append(base.OpenFlags, p.OpenFlags...)
+		p.Network = p.Network || base.Network
+	}
+	if err = p.check(); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+	return &p, nil
+}
+
+// check reports a profile that allows a syscall that opens files unconditionally, bypassing open_flags.
+func (p *Profile) check() error {
+	for _, name := range p.Syscalls {
+		if _, ok := openFlagsArg[name]; ok || name == "openat2" {
+			return fmt.Errorf("%s cannot be in syscalls, see open_flags", name)
+		}
+	}
+	return nil
+}
+
+func (p *Profile) allows(name string) bool {
+	return slices.Contains(p.Syscalls, name)
+}
+
+// openFlags returns the allowed open flags as numbers.
+func (p *Profile) openFlags() ([]uint64, error) {
+	var out []uint64
+	for _, flags := range p.OpenFlags {
+		var v uint64
+		for _, name := range strings.Split(flags, "|") {
+			f, ok := openFlagNames[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown open flag %q", name)
+			}
+			v |= f
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"syscall"
+	"time"
+)
+
+// the stages a run can end in
+const (
+	stageSetup = "setup" // the sandbox could not be prepared
+	stageBuild = "build"
+	stageRun   = "run"
+)
+
+// the limits that end a run
+const (
+	timeoutWall = "wall"
+	timeoutCPU  = "cpu"
+)
+
+// result is the report of a run, it is written as a single line of JSON to the result descriptor.
+// It is read by the server as protocol.Result, the two have to match.
+type result struct {
+	Stage      string `json:"stage"`                // the stage the run ended in
+	ExitCode   int    `json:"exit_code"`            // of the build or the program, -1 if killed by a signal
+	Signal     string `json:"signal,omitempty"`     // that killed the program
+	Timeout    string `json:"timeout,omitempty"`    // the time limit the program exceeded, if any
+	OOMKilled  bool   `json:"oom_killed,omitempty"` // the program was killed for exceeding the memory limit
+	WallTime   int64  `json:"wall_time_us"`
+	CPUUser    int64  `json:"cpu_user_us"`
+	CPUSystem  int64  `json:"cpu_system_us"`
+	PeakMemory int64  `json:"peak_memory_bytes"`
+	Unisolated bool   `json:"unisolated,omitempty"` // the program ran without namespaces, see allowUnisolatedKey
+	Error      string `json:"error,omitempty"`      // why the setup failed
+}
+
+func setupFailed(err error) result {
+	return result{Stage: stageSetup, ExitCode: 1, Error: err.Error()}
+}
+
+// exited records how a process ended.
+func (r *result) exited(ps *os.ProcessState) {
+	if ps == nil {
+		r.ExitCode = 1
+		return
+	}
+	r.ExitCode = ps.ExitCode()
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		r.Signal = ws.Signal().String()
+	}
+}
+
+func (r *result) setCPU(user, system time.Duration) {
+	r.CPUUser, r.CPUSystem = user.Microseconds(), system.Microseconds()
+}
+
+// exitCode is the exit code of the runner for the result.
+func (r *result) exitCode() int {
+	switch {
+	case r.Timeout != "":
+		return timeoutExitCode
+	case r.Stage == stageRun && r.ExitCode == 0 && r.Signal == "":
+		return 0
+	default:
+		return 1
+	}
+}
+
+// write writes the result to the descriptor fd, which is closed afterwards.
+func (r *result) write(fd int) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f := os.NewFile(uintptr(fd), "result")
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	uidRangeKey     = "SANDBOX_UID_RANGE" // e.g. "100000-100999", the UIDs and GIDs given to the runs
+	defaultUIDRange = "100000-100999"
+	uidLockDir      = ".uids" // under tmpOutputDir, a locked file per UID in use
+)
+
+// the capabilities the runner needs to run programs as another user, see capabilities(7)
+const (
+	capChown  = 0
+	capSetGID = 6
+	capSetUID = 7
+)
+
+// runUser is the unprivileged user of a run, nobody else uses it until it is released.
+type runUser struct {
+	UID, GID int
+	lock     *os.File
+}
+
+// allocateUser picks a free UID of the configured range, GID is the same number.
+// It fails when the runner is not allowed to switch users, then the program does not run.
+func allocateUser() (*runUser, error) {
+	if !hasCapabilities(capChown, capSetGID, capSetUID) {
+		return nil, errors.New("the runner cannot switch users")
+	}
+	first, last, err := uidRange()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(tmpOutputDir, uidLockDir)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// a random start keeps concurrent runners from contending for the same UIDs
+	n := last - first + 1
+	offset := rand.IntN(n)
+	for i := 0; i < n; i++ {
+		uid := first + (offset+i)%n
+		f, e := os.OpenFile(filepath.Join(dir, strconv.Itoa(uid)), os.O_CREATE|os.O_RDWR, 0600)
+		if e != nil {
+			return nil, e
+		}
+		// the lock goes away with the runner, even if it crashes
+		if e = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); e == nil {
+			return &runUser{UID: uid, GID: uid, lock: f}, nil
+		}
+		f.Close()
+	}
+	return nil, fmt.Errorf("all UIDs of %d-%d are in use", first, last)
+}
+
+func (u *runUser) release() {
+	u.lock.Close()
+}
+
+// own gives path to the user, with mode.
+func (u *runUser) own(path string, mode os.FileMode) error {
+	if err := os.Chown(path, u.UID, u.GID); err != nil {
+		return err
+	}
+	return os.Chmod(path, mode)
+}
+
+// reclaim gives everything under dir back to the runner, so that it can be removed.
+func reclaim(dir string) error {
+	uid, gid := os.Getuid(), os.Getgid()
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+		// the directory is read after this, it may have been made unreadable
+		if d.IsDir() {
+			return os.Chmod(path, 0700)
+		}
+		return nil
+	})
+}
+
+func uidRange() (first, last int, err error) {
+	s := os.Getenv(uidRangeKey)
+	if s == "" {
+		s = defaultUIDRange
+	}
+	from, to, ok := strings.Cut(s, "-")
+	if first, err = strconv.Atoi(from); ok && err == nil {
+		last, err = strconv.Atoi(to)
+	}
+	if !ok || err != nil || first <= 0 || last < first {
+		return 0, 0, fmt.Errorf("invalid %s: %q", uidRangeKey, s)
+	}
+	return first, last, nil
+}
+
+// hasCapabilities reports whether the runner has all the capabilities in its effective set,
+// root has them and so does the runner with file capabilities.
+func hasCapabilities(caps ...uint) bool {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+		eff, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return false
+		}
+		for _, c := range caps {
+			if eff&(1<<c) == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
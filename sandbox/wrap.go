@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// the files the wrapped program writes, in the directory of -artifacts
+const (
+	cpuProfileName  = "cpu.pprof"
+	heapProfileName = "heap.pprof"
+	traceName       = "trace.out"
+	// main of the program is renamed to this, the wrapper calls it
+	userMainName    = "sandboxUserMain"
+	wrapperFileName = "sandbox_wrap.go"
+)
+
+// the files are written to these file descriptors of the program, after the ones of the auditor
+const (
+	cpuProfileFd = iota + auditAckIn + 1
+	heapProfileFd
+	traceFd
+)
+
+// profilingSyscalls are added to the seccomp profile of a profiled program, for the SIGPROF timers of the runtime.
+var profilingSyscalls = []string{"setitimer", "timer_create", "timer_settime", "timer_delete"}
+
+// wrapping is how main of the program is wrapped.
+type wrapping struct {
+	Pprof bool   // CPU and heap profiles
+	Trace bool   // an execution trace
+	Dir   string // of the files
+}
+
+func (w wrapping) enabled() bool {
+	return w.Pprof || w.Trace
+}
+
+// wrapper profiles the CPU and traces while main of the program runs, and writes the heap profile after it returns
+// or panics. A program that exits or is killed has no profiles and a trace that may not be read.
+// The imports are named like userMainName, the package-level names of the program cannot be declared twice.
+var wrapper = template.Must(template.New("wrapper").Parse(`package main
+
+import (
+	sandboxOS "os"
+{{- if .Pprof}}
+	sandboxRuntime "runtime"
+	sandboxPprof "runtime/pprof"
+{{- end}}
+{{- if .Trace}}
+	sandboxTrace "runtime/trace"
+{{- end}}
+)
+
+func main() {
+{{- if .Pprof}}
+	sandboxRuntime.MemProfileRate = 4096
+	cpu, heap := sandboxOS.NewFile({{.CPUProfileFd}}, "{{.CPUProfileName}}"), sandboxOS.NewFile({{.HeapProfileFd}}, "{{.HeapProfileName}}")
+	if err := sandboxPprof.StartCPUProfile(cpu); err != nil {
+		panic(err)
+	}
+	defer func() {
+		sandboxPprof.StopCPUProfile()
+		// the heap profile is as of the last GC
+		sandboxRuntime.GC()
+		sandboxPprof.WriteHeapProfile(heap)
+	}()
+{{- end}}
+{{- if .Trace}}
+	if err := sandboxTrace.Start(sandboxOS.NewFile({{.TraceFd}}, "{{.TraceName}}")); err != nil {
+		panic(err)
+	}
+	defer sandboxTrace.Stop()
+{{- end}}
+	{{.UserMainName}}()
+}
+`))
+
+// wrapMain renames main of the program in the module and adds the wrapper.
+// It does nothing if the code does not parse or has no main, the build reports that.
+func wrapMain(moduleDir string, w wrapping) error {
+	path := filepath.Join(moduleDir, tmpFileName)
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	var name *ast.Ident
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == "main" {
+			name = fn.Name
+		}
+	}
+	if f.Name.Name != "main" || name == nil {
+		return nil
+	}
+
+	var b bytes.Buffer
+	err = wrapper.Execute(&b, map[string]any{
+		"Pprof":           w.Pprof,
+		"Trace":           w.Trace,
+		"CPUProfileFd":    cpuProfileFd,
+		"CPUProfileName":  cpuProfileName,
+		"HeapProfileFd":   heapProfileFd,
+		"HeapProfileName": heapProfileName,
+		"TraceFd":         traceFd,
+		"TraceName":       traceName,
+		"UserMainName":    userMainName,
+	})
+	if err != nil {
+		return err
+	}
+
+	// only the name changes, so the lines of the errors and the profiles stay the same
+	off := fset.Position(name.Pos()).Offset
+	src = append(src[:off:off], append([]byte(userMainName), src[off+len("main"):]...)...)
+	if err = os.WriteFile(path, src, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(moduleDir, wrapperFileName), b.Bytes(), 0644)
+}
+
+// createFiles creates the files the wrapped program writes in w.Dir, in the order of their descriptors
+// from cpuProfileFd. The ones it does not write are nil.
+func createFiles(w wrapping) ([]*os.File, error) {
+	if err := os.MkdirAll(w.Dir, 0700); err != nil {
+		return nil, err
+	}
+	names := []string{"", "", ""}
+	if w.Pprof {
+		names[0], names[1] = cpuProfileName, heapProfileName
+	}
+	if w.Trace {
+		names[2] = traceName
+	}
+	files := make([]*os.File, len(names))
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		f, err := os.Create(filepath.Join(w.Dir, name))
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		files[i] = f
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		if f != nil {
+			f.Close()
+		}
+	}
+}